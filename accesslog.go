@@ -0,0 +1,215 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// accessLogEntry describes a served request for the access log.
+type accessLogEntry struct {
+	Time     time.Time
+	Request  *http.Request
+	ClientIP string
+	Status   int
+	Bytes    int
+	Duration time.Duration
+}
+
+// accessLogFormat writes a single access log line for the entry.
+type accessLogFormat func(out io.Writer, e *accessLogEntry)
+
+// accessLogFormats are the formats accepted by -log-format.
+var accessLogFormats = map[string]accessLogFormat{
+	"text":     formatTextLog,
+	"json":     formatJSONLog,
+	"common":   formatCommonLog,
+	"combined": formatCombinedLog,
+}
+
+// accessLogger writes one line per request in the configured format.
+type accessLogger struct {
+	out    io.Writer
+	format accessLogFormat
+	ips    *clientIPResolver
+}
+
+// newAccessLogger returns a logger writing to out in the named format, or
+// rendering tmpl for each request when it is not empty.
+func newAccessLogger(out io.Writer, format, tmpl string, ips *clientIPResolver) (*accessLogger, error) {
+	if tmpl != "" {
+		t, err := template.New("log").Parse(tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+		return &accessLogger{out: out, format: templateLog(t), ips: ips}, nil
+	}
+
+	f, ok := accessLogFormats[format]
+	if !ok {
+		names := make([]string, 0, len(accessLogFormats))
+		for name := range accessLogFormats {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return nil, fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(names, ", "))
+	}
+	return &accessLogger{out: out, format: f, ips: ips}, nil
+}
+
+// Log writes the entry, filling in the client IP.
+func (l *accessLogger) Log(e *accessLogEntry) {
+	e.ClientIP = clientAddr(l.ips, e.Request)
+	l.format(l.out, e)
+}
+
+// formatTextLog writes the classic http-echo log line.
+func formatTextLog(out io.Writer, e *accessLogEntry) {
+	r := e.Request
+	fmt.Fprintf(out, httpLogFormat,
+		e.Time.Format(httpLogDateFormat),
+		r.Host, r.RemoteAddr, r.Method, r.URL.Path, r.Proto,
+		e.Status, e.Bytes, r.UserAgent(), e.Duration)
+}
+
+// clfDateFormat is the timestamp format of the Apache log formats.
+const clfDateFormat = "02/Jan/2006:15:04:05 -0700"
+
+// formatCommonLog writes the entry in the Apache Common Log Format.
+func formatCommonLog(out io.Writer, e *accessLogEntry) {
+	fmt.Fprintf(out, "%s\n", commonLogLine(e))
+}
+
+// formatCombinedLog writes the entry in the Apache Combined Log Format, the
+// Common Log Format followed by the referer and user agent.
+func formatCombinedLog(out io.Writer, e *accessLogEntry) {
+	r := e.Request
+	fmt.Fprintf(out, "%s %s %s\n", commonLogLine(e), clfQuote(r.Referer()), clfQuote(r.UserAgent()))
+}
+
+// commonLogLine formats the fields shared by the Apache log formats.
+func commonLogLine(e *accessLogEntry) string {
+	r := e.Request
+	user := "-"
+	if u, _, ok := r.BasicAuth(); ok && u != "" {
+		user = u
+	}
+	size := "-"
+	if e.Bytes > 0 {
+		size = strconv.Itoa(e.Bytes)
+	}
+	return fmt.Sprintf("%s - %s [%s] %s %d %s",
+		e.ClientIP, user, e.Time.Format(clfDateFormat),
+		clfQuote(r.Method+" "+r.RequestURI+" "+r.Proto), e.Status, size)
+}
+
+// clfQuote quotes a request derived value, escaping quotes and backslashes so
+// log parsers cannot be confused by crafted headers.
+func clfQuote(v string) string {
+	if v == "" {
+		return `"-"`
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+}
+
+// logTemplateData is available to -log-template.
+type logTemplateData struct {
+	Time       time.Time
+	Method     string
+	Path       string
+	Query      string
+	Proto      string
+	Host       string
+	Header     http.Header
+	RemoteAddr string
+	ClientIP   string
+	UserAgent  string
+	Status     int
+	Bytes      int
+	Duration   time.Duration
+	TraceID    string
+}
+
+// templateLog renders the template for each entry, adding a trailing newline
+// if the template does not end with one.
+func templateLog(t *template.Template) accessLogFormat {
+	return func(out io.Writer, e *accessLogEntry) {
+		r := e.Request
+		data := logTemplateData{
+			Time:       e.Time,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Query:      r.URL.RawQuery,
+			Proto:      r.Proto,
+			Host:       r.Host,
+			Header:     r.Header,
+			RemoteAddr: r.RemoteAddr,
+			ClientIP:   e.ClientIP,
+			UserAgent:  r.UserAgent(),
+			Status:     e.Status,
+			Bytes:      e.Bytes,
+			Duration:   e.Duration,
+		}
+		if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+			data.TraceID = sc.TraceID().String()
+		}
+
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, data); err != nil {
+			fmt.Fprintf(&buf, "failed to render -log-template: %s", err)
+		}
+		if !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+			buf.WriteByte('\n')
+		}
+		out.Write(buf.Bytes())
+	}
+}
+
+// jsonLogEntry is the shape of a -log-format json line.
+type jsonLogEntry struct {
+	Time       string  `json:"time"`
+	Host       string  `json:"host"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Proto      string  `json:"proto"`
+	Status     int     `json:"status"`
+	Bytes      int     `json:"bytes"`
+	DurationMS float64 `json:"duration_ms"`
+	ClientIP   string  `json:"client_ip"`
+	RemoteAddr string  `json:"remote_addr"`
+	UserAgent  string  `json:"user_agent"`
+}
+
+// formatJSONLog writes the entry as a single JSON object.
+func formatJSONLog(out io.Writer, e *accessLogEntry) {
+	r := e.Request
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(jsonLogEntry{
+		Time:       e.Time.Format(time.RFC3339Nano),
+		Host:       r.Host,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Proto:      r.Proto,
+		Status:     e.Status,
+		Bytes:      e.Bytes,
+		DurationMS: float64(e.Duration) / float64(time.Millisecond),
+		ClientIP:   e.ClientIP,
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+	})
+	out.Write(buf.Bytes())
+}
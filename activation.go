@@ -0,0 +1,129 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+var (
+	activationOnce  sync.Once
+	activationFiles []*os.File
+)
+
+// activatedListener returns the next listener inherited through systemd
+// socket activation, or nil when none are left. Listeners are handed out in
+// the order of the ListenStream= entries in the socket unit.
+func activatedListener() (net.Listener, error) {
+	f := nextActivationFile(syscall.SOCK_STREAM)
+	if f == nil {
+		return nil, nil
+	}
+	defer f.Close()
+
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use activated socket %d: %w", f.Fd(), err)
+	}
+	return ln, nil
+}
+
+// activatedPacketConn is like activatedListener for datagram sockets, given
+// by ListenDatagram= entries.
+func activatedPacketConn() (net.PacketConn, error) {
+	f := nextActivationFile(syscall.SOCK_DGRAM)
+	if f == nil {
+		return nil, nil
+	}
+	defer f.Close()
+
+	pc, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use activated socket %d: %w", f.Fd(), err)
+	}
+	return pc, nil
+}
+
+// nextActivationFile returns the next socket of the given type inherited
+// through socket activation or handed over by a parent process, or nil when
+// none are left. Stream and datagram sockets are matched up separately, so
+// each keeps its own order however they are interleaved.
+func nextActivationFile(sotype int) *os.File {
+	activationOnce.Do(func() {
+		activationFiles = activationSockets()
+	})
+	for i, f := range activationFiles {
+		if socketType(f) == sotype {
+			activationFiles = slices.Delete(activationFiles, i, i+1)
+			return f
+		}
+	}
+	return nil
+}
+
+// socketType returns the SO_TYPE of the socket f, or -1 when it cannot be
+// determined.
+func socketType(f *os.File) int {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return -1
+	}
+	sotype := -1
+	rc.Control(func(fd uintptr) {
+		if v, err := syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_TYPE); err == nil {
+			sotype = v
+		}
+	})
+	return sotype
+}
+
+// activationSockets returns the file descriptors described by LISTEN_PID and
+// LISTEN_FDS, or handed over by a parent process on SIGUSR2. The variables
+// are unset afterwards so they are not inherited by child processes.
+func activationSockets() []*os.File {
+	n := inheritedFDs()
+	if n <= 0 {
+		return nil
+	}
+
+	files := make([]*os.File, 0, n)
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		syscall.CloseOnExec(fd)
+		files = append(files, os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd)))
+	}
+
+	return files
+}
+
+// inheritedFDs returns the number of listening sockets passed to the process,
+// starting at listenFDsStart.
+func inheritedFDs() int {
+	if v := os.Getenv(upgradeFDsEnv); v != "" {
+		os.Unsetenv(upgradeFDsEnv)
+		n, _ := strconv.Atoi(v)
+		return n
+	}
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return 0
+	}
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	return n
+}
@@ -0,0 +1,19 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !unix
+
+package main
+
+import "net"
+
+// activatedListener always returns nil, socket activation is only available
+// on unix systems.
+func activatedListener() (net.Listener, error) {
+	return nil, nil
+}
+
+// activatedPacketConn always returns nil, like activatedListener.
+func activatedPacketConn() (net.PacketConn, error) {
+	return nil, nil
+}
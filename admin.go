@@ -0,0 +1,85 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// maxAdminBody caps the size of values sent to the admin API.
+const maxAdminBody = 1 << 20
+
+// adminHandler serves the admin API used to reconfigure the running server:
+//
+//	PUT  /admin/text         replace the response text with the request body
+//	PUT  /admin/status-code  replace the response status code
+//	POST /admin/reload       re-read the config file, like SIGHUP
+//	POST /admin/shutdown     shut the server down gracefully
+//	POST /admin/{probe}/fail make /health, /livez or /readyz return 503
+//	POST /admin/{probe}/ok   make the probe return 200 again
+func adminHandler(live *liveHandler, accessLog *accessLogger, shutdown func()) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /admin/text", adminSet(live, "text"))
+	mux.HandleFunc("PUT /admin/status-code", adminSet(live, "status-code"))
+	mux.HandleFunc("POST /admin/reload", func(w http.ResponseWriter, r *http.Request) {
+		if err := live.Reload(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("[INFO] reloaded configuration through the admin API")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /admin/shutdown", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		shutdown()
+	})
+	mux.HandleFunc("POST /admin/{probe}/{state}", adminProbe())
+	return httpLog(accessLog, mux.ServeHTTP)
+}
+
+// adminProbe returns a handler flipping the probe named in the path to the
+// ok or fail state.
+func adminProbe() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("probe")
+		state, ok := health.probe(name)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.PathValue("state") {
+		case "ok":
+			state.Store(true)
+		case "fail":
+			state.Store(false)
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		log.Printf("[INFO] set /%s to %s through the admin API", name, r.PathValue("state"))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// adminSet returns a handler setting the named flag to the request body.
+func adminSet(live *liveHandler, name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAdminBody))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to read body: %s", err), http.StatusBadRequest)
+			return
+		}
+		value := strings.TrimSuffix(string(b), "\n")
+		if err := live.Set(name, value); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("[INFO] set -%s through the admin API", name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
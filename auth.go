@@ -0,0 +1,91 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// basicAuthRealm is the realm sent in Basic authentication challenges.
+const basicAuthRealm = "http-echo"
+
+// parseBasicAuth parses "user:pass" pairs as given to -basic-auth, mapping
+// each user to its password.
+func parseBasicAuth(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	creds := make(map[string]string, len(values))
+	for _, v := range values {
+		user, pass, ok := strings.Cut(v, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("invalid credentials %q, expected \"user:pass\"", v)
+		}
+		creds[user] = pass
+	}
+	return creds, nil
+}
+
+// withBasicAuth requires requests to carry Basic credentials matching one of
+// the given users, answering others with a 401 challenge. A nil map leaves
+// requests untouched.
+func withBasicAuth(creds map[string]string, h http.HandlerFunc) http.HandlerFunc {
+	if creds == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || !checkPassword(creds, user, pass) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", basicAuthRealm))
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// checkPassword reports whether pass is the password of user, comparing in
+// constant time.
+func checkPassword(creds map[string]string, user, pass string) bool {
+	want, ok := creds[user]
+	if !ok {
+		return false
+	}
+	a, b := sha256.Sum256([]byte(pass)), sha256.Sum256([]byte(want))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+// withAPIKey requires requests to carry one of the given keys in the header.
+// Requests without the header are answered with a 401, those with an unknown
+// key with a 403. No keys leave requests untouched.
+func withAPIKey(header string, keys []string, h http.HandlerFunc) http.HandlerFunc {
+	if len(keys) == 0 {
+		return h
+	}
+	sums := make([][sha256.Size]byte, len(keys))
+	for i, k := range keys {
+		sums[i] = sha256.Sum256([]byte(k))
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(header)
+		if key == "" {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		sum := sha256.Sum256([]byte(key))
+		valid := 0
+		for _, s := range sums {
+			valid |= subtle.ConstantTimeCompare(sum[:], s[:])
+		}
+		if valid == 0 {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
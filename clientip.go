@@ -0,0 +1,151 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientIPResolver determines the client address of a request. The
+// X-Forwarded-For header is only honored when the connection comes from one
+// of the trusted proxy networks.
+type clientIPResolver struct {
+	trusted []netip.Prefix
+}
+
+// newClientIPResolver returns a resolver trusting the given CIDRs. Bare
+// addresses are treated as single host networks.
+func newClientIPResolver(cidrs []string) (*clientIPResolver, error) {
+	var c clientIPResolver
+	for _, s := range cidrs {
+		p, err := parsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		c.trusted = append(c.trusted, p)
+	}
+	return &c, nil
+}
+
+// ClientIP returns the address of the client that sent the request. Trusted
+// proxies are skipped from the right of the X-Forwarded-For chain, so the
+// first untrusted hop is the client. The zero address is returned when the
+// remote address is not an IP, e.g. for unix sockets.
+func (c *clientIPResolver) ClientIP(r *http.Request) netip.Addr {
+	ip := remoteIP(r)
+	if !c.isTrusted(ip) {
+		return ip
+	}
+
+	chain := forwardedFor(r)
+	for i := len(chain) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(chain[i])
+		if err != nil {
+			break
+		}
+		ip = hop.Unmap()
+		if !c.isTrusted(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+func (c *clientIPResolver) isTrusted(ip netip.Addr) bool {
+	return containsIP(c.trusted, ip)
+}
+
+// remoteIP returns the IP of the connection peer.
+func remoteIP(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ip.Unmap()
+}
+
+// forwardedFor returns the addresses in every X-Forwarded-For header of the
+// request, in order.
+func forwardedFor(r *http.Request) []string {
+	var chain []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(v, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				chain = append(chain, hop)
+			}
+		}
+	}
+	return chain
+}
+
+// parsePrefix parses a CIDR, accepting a bare address as a single host.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		ip, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid address %q: %w", s, err)
+		}
+		ip = ip.Unmap()
+		return netip.PrefixFrom(ip, ip.BitLen()), nil
+	}
+
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR %q: %w", s, err)
+	}
+	if p.Addr().Is4In6() {
+		p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	return p.Masked(), nil
+}
+
+// parsePrefixes parses the comma separated CIDRs in every value.
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, v := range values {
+		for _, s := range splitList(v) {
+			p, err := parsePrefix(s)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+// containsIP reports whether any of the networks contains ip.
+func containsIP(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// withIPFilter rejects requests with a 403 when the client IP is in one of
+// the deny networks, or when allow networks are given and the client IP is in
+// none of them. Without networks requests are left untouched.
+func withIPFilter(allow, deny []netip.Prefix, ips *clientIPResolver, h http.HandlerFunc) http.HandlerFunc {
+	if len(allow) == 0 && len(deny) == 0 {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := ips.ClientIP(r)
+		if containsIP(deny, ip) || (len(allow) > 0 && !containsIP(allow, ip)) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// encodings are the supported content codings, most preferred first.
+var encodings = []string{"br", "gzip", "deflate"}
+
+// encoder is a compressing writer that can push out buffered data.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// newEncoder returns a writer compressing to w with the content coding, at the
+// given level or the default level when it is -1.
+func newEncoder(encoding string, w io.Writer, level int) (encoder, error) {
+	switch encoding {
+	case "br":
+		if level == -1 {
+			level = brotli.DefaultCompression
+		}
+		return brotli.NewWriterLevel(w, level), nil
+	case "gzip":
+		return gzip.NewWriterLevel(w, level)
+	case "deflate":
+		return zlib.NewWriterLevel(w, level)
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+}
+
+// acceptedEncoding picks the supported content coding the client prefers
+// according to its Accept-Encoding header, or "" for none.
+func acceptedEncoding(r *http.Request) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		for _, enc := range encodings {
+			if !strings.EqualFold(name, enc) || q <= 0 {
+				continue
+			}
+			if q > bestQ || (q == bestQ && preference(enc) < preference(best)) {
+				best, bestQ = enc, q
+			}
+		}
+	}
+	return best
+}
+
+// preference returns the position of the content coding in encodings.
+func preference(encoding string) int {
+	for i, enc := range encodings {
+		if enc == encoding {
+			return i
+		}
+	}
+	return len(encodings)
+}
+
+// withCompression compresses responses of at least minSize bytes with the
+// content coding preferred by the client. Responses that already carry a
+// Content-Encoding are left alone, as are connection upgrades such as /ws.
+func withCompression(enabled bool, minSize, level int, h http.HandlerFunc) http.HandlerFunc {
+	if !enabled {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r)
+		if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+			h(w, r)
+			return
+		}
+
+		cw := &compressResponseWriter{
+			ResponseWriter: w,
+			encoding:       encoding,
+			level:          level,
+			minSize:        minSize,
+			status:         http.StatusOK,
+		}
+		defer cw.Close()
+		h(cw, r)
+	}
+}
+
+// compressResponseWriter buffers the start of the response until it is known
+// to be large enough to compress.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+	level    int
+	minSize  int
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	enc         encoder
+}
+
+// WriteHeader implements the http.ResponseWriter interface. The status code
+// is held back until the response is known to be compressed or not.
+func (w *compressResponseWriter) WriteHeader(code int) {
+	if code < 200 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = code
+}
+
+// Write implements the http.ResponseWriter interface.
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < w.minSize {
+			return len(b), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.enc != nil {
+		return w.enc.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends everything written so far, compressing it when possible.
+func (w *compressResponseWriter) Flush() error {
+	if !w.decided {
+		if err := w.decide(true); err != nil {
+			return err
+		}
+	}
+	if w.enc != nil {
+		if err := w.enc.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Close sends the remaining response and finishes the compressed stream.
+func (w *compressResponseWriter) Close() error {
+	if !w.decided {
+		if err := w.decide(false); err != nil {
+			return err
+		}
+	}
+	if w.enc != nil {
+		return w.enc.Close()
+	}
+	return nil
+}
+
+// decide sends the status code and buffered data, compressed unless compress
+// is false, the handler already encoded the response itself, or the response
+// has no body or carries a byte range of it.
+func (w *compressResponseWriter) decide(compress bool) error {
+	w.decided = true
+	hdr := w.Header()
+	if compress && hdr.Get("Content-Encoding") == "" && w.status != http.StatusNoContent && w.status != http.StatusPartialContent && w.status != http.StatusNotModified {
+		enc, err := newEncoder(w.encoding, w.ResponseWriter, w.level)
+		if err != nil {
+			return err
+		}
+		w.enc = enc
+		hdr.Set("Content-Encoding", w.encoding)
+		hdr.Del("Content-Length")
+		// The encoded bytes differ from the identity body, so a strong
+		// validator no longer applies.
+		if etag := hdr.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			hdr.Set("ETag", "W/"+etag)
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.enc != nil {
+		_, err := w.enc.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// Unwrap returns the wrapped writer for use by http.ResponseController.
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// compressedResponse is the document returned by /gzip, /deflate and /brotli.
+type compressedResponse struct {
+	Encoding string            `json:"encoding"`
+	Method   string            `json:"method"`
+	Headers  map[string]string `json:"headers"`
+	Origin   string            `json:"origin"`
+}
+
+// httpCompressed returns a JSON document describing the request, always
+// compressed with the content coding regardless of Accept-Encoding.
+func httpCompressed(encoding string, ips *clientIPResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := marshalJSON(compressedResponse{
+			Encoding: encoding,
+			Method:   r.Method,
+			Headers:  flattenHeaders(r),
+			Origin:   clientAddr(ips, r),
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var out bytes.Buffer
+		enc, err := newEncoder(encoding, &out, -1)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		enc.Write(body)
+		enc.Close()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", encoding)
+		w.Header().Set("Content-Length", strconv.Itoa(out.Len()))
+		w.Write(out.Bytes())
+	}
+}
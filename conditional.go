@@ -0,0 +1,64 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// withConditional answers conditional requests for the body written by h. The
+// response gets a strong ETag derived from the body and a Last-Modified of
+// modTime, and If-None-Match or If-Modified-Since requests that match get a
+// 304 without a body. Responses with a status code set by h are passed
+// through as they are.
+func withConditional(enabled bool, modTime func() time.Time, h http.HandlerFunc) http.HandlerFunc {
+	if !enabled {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		bw := &bufferResponseWriter{ResponseWriter: w}
+		h(bw, r)
+		if bw.status != 0 {
+			bw.send()
+			return
+		}
+
+		sum := sha256.Sum256(bw.buf.Bytes())
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+		w.Header().Del("Content-Length")
+		http.ServeContent(w, r, "", modTime(), bytes.NewReader(bw.buf.Bytes()))
+	}
+}
+
+// withRanges answers Range requests, including If-Range and multiple ranges,
+// with the matching parts of the body written by h and a 206. Requests
+// without a Range header, and responses with a status code set by h, are
+// passed through as they are.
+func withRanges(modTime func() time.Time, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "" {
+			w.Header().Set("Accept-Ranges", "bytes")
+			h(w, r)
+			return
+		}
+
+		bw := &bufferResponseWriter{ResponseWriter: w}
+		h(bw, r)
+		if bw.status != 0 {
+			bw.send()
+			return
+		}
+		w.Header().Del("Content-Length")
+		http.ServeContent(w, r, "", modTime(), bytes.NewReader(bw.buf.Bytes()))
+	}
+}
+
+// fixedTime returns a function reporting t, for content that does not change.
+func fixedTime(t time.Time) func() time.Time {
+	return func() time.Time { return t }
+}
@@ -0,0 +1,301 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+	"go.yaml.in/yaml/v3"
+)
+
+// loadConfig reads the config file and applies every setting in it to the
+// flag of the same name. Flags set on the command line are left untouched so
+// they take precedence over the file. Underscores in keys are treated as
+// hyphens, so both listen_addr and listen-addr work.
+func loadConfig(path string, explicit map[string]bool) error {
+	values, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		name := strings.ReplaceAll(key, "_", "-")
+		if name == "config" {
+			return fmt.Errorf("config files cannot include other config files")
+		}
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf("unknown setting %q", key)
+		}
+		if explicit[name] {
+			continue
+		}
+
+		vs, err := configValues(name, values[key])
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+		for _, v := range vs {
+			if err := flag.Set(name, v); err != nil {
+				return fmt.Errorf("invalid %s: %w", key, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// resetFlags restores every flag not set on the command line to its default,
+// so settings removed from the config file do not linger after a reload.
+func resetFlags(explicit map[string]bool) {
+	flag.VisitAll(func(f *flag.Flag) {
+		if explicit[f.Name] {
+			return
+		}
+		if s, ok := f.Value.(*stringSliceFlag); ok {
+			s.Reset()
+			return
+		}
+		f.Value.Set(f.DefValue)
+	})
+}
+
+// readConfig decodes the config file into generic values. The format is
+// chosen by extension: .hcl, .json, .yaml or .yml.
+func readConfig(path string) (map[string]any, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]any
+	switch ext := filepath.Ext(path); ext {
+	case ".hcl":
+		values, err = decodeHCL(path, src)
+	case ".json":
+		err = json.Unmarshal(src, &values)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(src, &values)
+	default:
+		return nil, fmt.Errorf("unsupported config file extension %q", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// decodeHCL evaluates the attributes of an HCL file. Values are converted
+// through JSON so they have the same shape as the other formats.
+func decodeHCL(path string, src []byte) (map[string]any, error) {
+	f, diags := hclsyntax.ParseConfig(src, path, hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	attrs, diags := f.Body.JustAttributes()
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	values := make(map[string]any, len(attrs))
+	for name, attr := range attrs {
+		v, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() {
+			return nil, diags
+		}
+		b, err := ctyjson.Marshal(v, v.Type())
+		if err != nil {
+			return nil, err
+		}
+		var out any
+		if err := json.Unmarshal(b, &out); err != nil {
+			return nil, err
+		}
+		values[name] = out
+	}
+	return values, nil
+}
+
+// configValues converts a config value into the values to set on the named
+// flag. Lists set a repeatable flag once per element.
+func configValues(name string, v any) ([]string, error) {
+	switch v := v.(type) {
+	case []any:
+		var out []string
+		for _, elem := range v {
+			vs, err := configValues(name, elem)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, vs...)
+		}
+		return out, nil
+	case map[string]any:
+		var s string
+		var err error
+		switch name {
+		case "header":
+			return headersFromConfig(v)
+		case "path":
+			pattern, ok := v["pattern"].(string)
+			if !ok {
+				return routesFromConfig(v)
+			}
+			s, err = routeValue(pattern, v)
+		case "listen":
+			s, err = listenFromConfig(v)
+		default:
+			return nil, fmt.Errorf("expected a single value or a list")
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []string{s}, nil
+	default:
+		s, err := scalarString(v)
+		if err != nil {
+			return nil, err
+		}
+		return []string{s}, nil
+	}
+}
+
+// scalarString formats a decoded scalar as a flag value.
+func scalarString(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", v)
+	}
+}
+
+// listenFromConfig converts a listener object such as
+// {address = ":8443", tls = true, bind_device = "eth1"} into a -listen value.
+func listenFromConfig(obj map[string]any) (string, error) {
+	addr, ok := obj["address"].(string)
+	if !ok || addr == "" {
+		return "", fmt.Errorf("listener is missing an address")
+	}
+
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		if k != "address" {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+
+	parts := []string{addr}
+	for _, k := range keys {
+		opt := strings.ReplaceAll(k, "_", "-")
+		switch val := obj[k].(type) {
+		case bool:
+			if val {
+				parts = append(parts, opt)
+			}
+		default:
+			s, err := scalarString(val)
+			if err != nil {
+				return "", fmt.Errorf("listener %s: %w", addr, err)
+			}
+			parts = append(parts, opt+"="+s)
+		}
+	}
+	return strings.Join(parts, ","), nil
+}
+
+// routesFromConfig converts a map of patterns to either text or route objects
+// such as {text = "hello", status = 201} into -path values.
+func routesFromConfig(v map[string]any) ([]string, error) {
+	patterns := make([]string, 0, len(v))
+	for p := range v {
+		patterns = append(patterns, p)
+	}
+	slices.Sort(patterns)
+
+	out := make([]string, 0, len(v))
+	for _, p := range patterns {
+		route, ok := v[p].(map[string]any)
+		if !ok {
+			route = map[string]any{"text": v[p]}
+		}
+		s, err := routeValue(p, route)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// routeValue formats a route as "pattern=text:status". The status, 200 unless
+// given, is always included so a text ending in a colon and three digits is
+// not mistaken for one.
+func routeValue(pattern string, route map[string]any) (string, error) {
+	if pattern == "" {
+		return "", fmt.Errorf("route is missing a pattern")
+	}
+	text, err := scalarString(route["text"])
+	if err != nil {
+		return "", fmt.Errorf("route %s: %w", pattern, err)
+	}
+	code := "200"
+	if status, ok := route["status"]; ok {
+		code, err = scalarString(status)
+		if err != nil {
+			return "", fmt.Errorf("route %s: %w", pattern, err)
+		}
+	}
+	return pattern + "=" + text + ":" + code, nil
+}
+
+// headersFromConfig converts a map of header names to a value or list of
+// values into -header values.
+func headersFromConfig(v map[string]any) ([]string, error) {
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var out []string
+	for _, name := range names {
+		values, ok := v[name].([]any)
+		if !ok {
+			values = []any{v[name]}
+		}
+		for _, val := range values {
+			s, err := scalarString(val)
+			if err != nil {
+				return nil, fmt.Errorf("header %s: %w", name, err)
+			}
+			out = append(out, name+": "+s)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,87 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// corsPolicy holds the allowed origins, methods and headers for cross-origin
+// requests.
+type corsPolicy struct {
+	origins []string
+	methods string
+	headers string
+}
+
+// newCORSPolicy returns the policy for the given comma separated origins,
+// methods and headers. It returns nil when no origin is allowed. An origin of
+// * allows every origin, and empty headers allow whatever the preflight
+// request asks for.
+func newCORSPolicy(origins, methods, headers string) *corsPolicy {
+	p := &corsPolicy{
+		origins: splitList(origins),
+		methods: strings.Join(splitList(strings.ToUpper(methods)), ", "),
+		headers: strings.Join(splitList(headers), ", "),
+	}
+	if len(p.origins) == 0 {
+		return nil
+	}
+	return p
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for the origin,
+// or an empty string when it is not allowed.
+func (p *corsPolicy) allowOrigin(origin string) string {
+	switch {
+	case slices.Contains(p.origins, "*"):
+		return "*"
+	case slices.Contains(p.origins, origin):
+		return origin
+	}
+	return ""
+}
+
+// withCORS adds the CORS headers of the policy to responses for allowed
+// origins, and answers preflight requests with a 204 without calling h. A nil
+// policy leaves requests untouched.
+func withCORS(p *corsPolicy, h http.HandlerFunc) http.HandlerFunc {
+	if p == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			h(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		allow := p.allowOrigin(origin)
+		if allow == "" {
+			h(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", allow)
+
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+			h(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		w.Header().Set("Access-Control-Allow-Methods", p.methods)
+		headers := p.headers
+		if headers == "" {
+			headers = r.Header.Get("Access-Control-Request-Headers")
+		}
+		if headers != "" {
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,630 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// anythingResponse is the document returned by the /anything endpoint.
+type anythingResponse struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Args    url.Values        `json:"args"`
+	Headers map[string]string `json:"headers"`
+	Origin  string            `json:"origin"`
+	Body    string            `json:"body"`
+}
+
+// httpAnything reflects the request back as a JSON document, in the style of
+// httpbin's /anything endpoint.
+func httpAnything(ips *clientIPResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(w, anythingResponse{
+			Method:  r.Method,
+			URL:     requestURL(r),
+			Args:    r.URL.Query(),
+			Headers: flattenHeaders(r),
+			Origin:  clientAddr(ips, r),
+			Body:    string(body),
+		})
+	}
+}
+
+// writeJSON writes v as an indented JSON document.
+func writeJSON(w http.ResponseWriter, v any) {
+	b, err := marshalJSON(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// marshalJSON encodes v as an indented JSON document without escaping HTML.
+func marshalJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// requestURL reconstructs the absolute URL the client requested.
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+// flattenHeaders returns the request headers, including Host, with repeated
+// values joined by commas.
+func flattenHeaders(r *http.Request) map[string]string {
+	headers := make(map[string]string, len(r.Header)+1)
+	for k, v := range r.Header {
+		headers[k] = strings.Join(v, ",")
+	}
+	headers["Host"] = r.Host
+	return headers
+}
+
+// clientAddr returns the client IP of the request, falling back to the raw
+// remote address for non-IP transports such as unix sockets.
+func clientAddr(ips *clientIPResolver, r *http.Request) string {
+	if ip := ips.ClientIP(r); ip.IsValid() {
+		return ip.String()
+	}
+	return r.RemoteAddr
+}
+
+// maxDripBytes caps the size of a /drip response.
+const maxDripBytes = 10 << 20
+
+// httpDrip trickles ?bytes= asterisks, default 10, evenly over ?duration=,
+// default 2s, in the style of httpbin's /drip endpoint. ?code= sets the status
+// code. An initial ?delay= is waited out first, capped at maxEndpointDelay,
+// unless max is set, in which case withDelay has already waited it out.
+func httpDrip(max time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		var delay time.Duration
+		if v := q.Get(delayParam); v != "" && max <= 0 {
+			d, err := parseDelay(v)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			delay = min(d, maxEndpointDelay)
+		}
+		n, err := queryInt(q, "bytes", 10)
+		if err != nil || n < 0 || n > maxDripBytes {
+			http.Error(w, "invalid bytes", http.StatusBadRequest)
+			return
+		}
+		duration, err := queryDuration(q, "duration", 2*time.Second)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		code, err := queryInt(q, "code", http.StatusOK)
+		if err != nil || code < 200 || code > 599 {
+			http.Error(w, "invalid code", http.StatusBadRequest)
+			return
+		}
+		if !sleep(r, delay) {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		// Sent as is, so withCompression does not batch the bytes up.
+		w.Header().Set("Content-Encoding", "identity")
+		w.Header().Set("Content-Length", strconv.Itoa(n))
+		w.WriteHeader(code)
+
+		rc := http.NewResponseController(w)
+		var interval time.Duration
+		if n > 0 {
+			interval = duration / time.Duration(n)
+		}
+		for i := 0; i < n; i++ {
+			if i > 0 && !sleep(r, interval) {
+				return
+			}
+			if _, err := w.Write([]byte("*")); err != nil {
+				return
+			}
+			rc.Flush()
+		}
+	}
+}
+
+// queryInt returns the integer query parameter, or def when it is not set.
+func queryInt(q url.Values, name string, def int) (int, error) {
+	v := q.Get(name)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
+// queryDuration returns the query parameter as a duration such as 2s or a
+// number of seconds, or def when it is not set.
+func queryDuration(q url.Values, name string, def time.Duration) (time.Duration, error) {
+	v := q.Get(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := parseDelay(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return d, nil
+}
+
+// maxStreamLines caps the number of lines sent by /stream/{n}.
+const maxStreamLines = 100
+
+// streamLine is a single line of a /stream/{n} response.
+type streamLine struct {
+	ID      int               `json:"id"`
+	URL     string            `json:"url"`
+	Args    url.Values        `json:"args"`
+	Headers map[string]string `json:"headers"`
+	Origin  string            `json:"origin"`
+}
+
+// httpStream sends n JSON lines describing the request, at most 100, flushing
+// after each so it arrives in separate chunks.
+func httpStream(ips *clientIPResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(r.PathValue("n"))
+		if err != nil || n < 0 {
+			http.Error(w, "invalid number of lines", http.StatusBadRequest)
+			return
+		}
+		n = min(n, maxStreamLines)
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		rc := http.NewResponseController(w)
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		line := streamLine{
+			URL:     requestURL(r),
+			Args:    r.URL.Query(),
+			Headers: flattenHeaders(r),
+			Origin:  clientAddr(ips, r),
+		}
+		for i := 0; i < n; i++ {
+			line.ID = i
+			if err := enc.Encode(line); err != nil {
+				return
+			}
+			rc.Flush()
+		}
+	}
+}
+
+// maxSSEEvents caps the number of events sent by /sse.
+const maxSSEEvents = 1000
+
+// sseEvent is the data of a single /sse event.
+type sseEvent struct {
+	ID        int       `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// httpSSE streams ?count= server-sent events, default 10 and at most 1000,
+// one every ?interval=, default 1s. Each event carries its sequence number as
+// the event ID, so a client reconnecting with Last-Event-ID continues after
+// the last event it received. ?retry= sets the reconnection delay advertised
+// to the client.
+func httpSSE() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		count, err := queryInt(q, "count", 10)
+		if err != nil || count < 0 || count > maxSSEEvents {
+			http.Error(w, "invalid count", http.StatusBadRequest)
+			return
+		}
+		interval, err := queryDuration(q, "interval", time.Second)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		retry, err := queryDuration(q, "retry", 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		next := 0
+		if v := r.Header.Get("Last-Event-ID"); v != "" {
+			last, err := strconv.Atoi(v)
+			if err != nil || last < 0 {
+				http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+				return
+			}
+			next = last + 1
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		if next >= count {
+			// Everything was sent already, 204 tells the client to stop
+			// reconnecting.
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		rc := http.NewResponseController(w)
+		if retry > 0 {
+			fmt.Fprintf(w, "retry: %d\n\n", retry.Milliseconds())
+		}
+		for i := next; i < count; i++ {
+			if i > next && !sleep(r, interval) {
+				return
+			}
+			data, err := json.Marshal(sseEvent{ID: i, Timestamp: time.Now().UTC()})
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: tick\ndata: %s\n\n", i, data); err != nil {
+				return
+			}
+			rc.Flush()
+		}
+	}
+}
+
+// maxBytes caps the size of a /bytes/{n} response.
+const maxBytes = 100 << 20
+
+// httpBytes sends n bytes of pseudo-random data, at most 100MB. The data is
+// generated from ?seed=, default 0, so the same request always returns the
+// same bytes, and Range requests, including multiple ranges, get the matching
+// parts of it.
+func httpBytes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(r.PathValue("n"))
+		if err != nil || n < 0 || n > maxBytes {
+			http.Error(w, "invalid number of bytes", http.StatusBadRequest)
+			return
+		}
+		var seed uint64
+		if v := r.URL.Query().Get("seed"); v != "" {
+			seed, err = strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid seed %q", v), http.StatusBadRequest)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("ETag", fmt.Sprintf(`"%d-%d"`, n, seed))
+		http.ServeContent(w, r, "", time.Time{}, &randomBytes{seed: seed, size: int64(n)})
+	}
+}
+
+// randomBlockSize is the size of the blocks randomBytes generates at once.
+const randomBlockSize = 32 << 10
+
+// randomBytes is a seekable stream of pseudo-random bytes. Every block is
+// generated from the seed and its index, so any offset can be read without
+// generating what comes before it.
+type randomBytes struct {
+	seed  uint64
+	size  int64
+	off   int64
+	block []byte
+	index int64
+}
+
+// Read implements the io.Reader interface.
+func (b *randomBytes) Read(p []byte) (int, error) {
+	if b.off >= b.size {
+		return 0, io.EOF
+	}
+	index := b.off / randomBlockSize
+	if b.block == nil || b.index != index {
+		if b.block == nil {
+			b.block = make([]byte, randomBlockSize)
+		}
+		rng := rand.New(rand.NewPCG(b.seed, uint64(index)))
+		for i := range b.block {
+			b.block[i] = byte(rng.Uint32())
+		}
+		b.index = index
+	}
+	start := b.off % randomBlockSize
+	end := min(int64(randomBlockSize), start+b.size-b.off)
+	n := copy(p, b.block[start:end])
+	b.off += int64(n)
+	return n, nil
+}
+
+// Seek implements the io.Seeker interface.
+func (b *randomBytes) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += b.off
+	case io.SeekEnd:
+		offset += b.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	b.off = offset
+	return offset, nil
+}
+
+// httpStatus responds with the status code given in the path. A comma
+// separated list picks one of the codes at random, weighted by an optional
+// ":weight" suffix, e.g. /status/200:0.9,500:0.1. Redirects point at /.
+// Informational 1xx codes other than 101 are sent as an interim response,
+// followed by a final 200 whose body says so.
+func httpStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, err := pickStatus(r.PathValue("codes"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if code < 200 && code != http.StatusSwitchingProtocols {
+			w.WriteHeader(code)
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprintf(w, "sent interim response %d, final status is 200\n", code)
+			return
+		}
+		if isRedirect(code) && code != http.StatusNotModified {
+			w.Header().Set("Location", "/")
+		}
+		w.WriteHeader(code)
+	}
+}
+
+// pickStatus parses a list of weighted status codes and picks one of them.
+func pickStatus(v string) (int, error) {
+	var codes []int
+	var weights []float64
+	var total float64
+	for _, entry := range strings.Split(v, ",") {
+		c, w, hasWeight := strings.Cut(entry, ":")
+		code, err := strconv.Atoi(c)
+		if err != nil || code < 100 || code > 599 {
+			return 0, fmt.Errorf("invalid status code %q", c)
+		}
+		weight := 1.0
+		if hasWeight {
+			weight, err = strconv.ParseFloat(w, 64)
+			if err != nil || weight < 0 {
+				return 0, fmt.Errorf("invalid weight %q", w)
+			}
+		}
+		codes = append(codes, code)
+		weights = append(weights, weight)
+		total += weight
+	}
+	if total == 0 {
+		return 0, fmt.Errorf("weights must not all be zero")
+	}
+
+	x := rand.Float64() * total
+	for i, weight := range weights {
+		if x < weight {
+			return codes[i], nil
+		}
+		x -= weight
+	}
+	return codes[len(codes)-1], nil
+}
+
+// httpHeaders reflects the request headers as a JSON document, in the style
+// of httpbin's /headers endpoint.
+func httpHeaders() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"headers": flattenHeaders(r)})
+	}
+}
+
+// ipResponse is the document returned by the /ip endpoint.
+type ipResponse struct {
+	Origin       string   `json:"origin"`
+	RemoteAddr   string   `json:"remote_addr"`
+	ForwardedFor []string `json:"forwarded_for,omitempty"`
+}
+
+// httpIP reports the client address of the request, along with the
+// X-Forwarded-For chain when the connection comes from a trusted proxy.
+func httpIP(ips *clientIPResolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := ipResponse{
+			Origin:     clientAddr(ips, r),
+			RemoteAddr: r.RemoteAddr,
+		}
+		if ips.isTrusted(remoteIP(r)) {
+			resp.ForwardedFor = forwardedFor(r)
+		}
+		writeJSON(w, resp)
+	}
+}
+
+// httpUUID returns a new random (version 4) UUID.
+func httpUUID() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var b [16]byte
+		crand.Read(b[:])
+		b[6] = b[6]&0x0f | 0x40
+		b[8] = b[8]&0x3f | 0x80
+		id := fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+		writeJSON(w, map[string]string{"uuid": id})
+	}
+}
+
+// randomCharsets are the character sets accepted by /random.
+var randomCharsets = map[string]string{
+	"hex":    "0123456789abcdef",
+	"digits": "0123456789",
+	"alpha":  "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"alnum":  "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ",
+}
+
+// maxRandomLen caps the length of a /random value.
+const maxRandomLen = 1 << 20
+
+// httpRandom returns a random string of ?len= characters, default 32, from
+// ?charset=, default alnum.
+func httpRandom() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		n, err := queryInt(q, "len", 32)
+		if err != nil || n < 0 || n > maxRandomLen {
+			http.Error(w, "invalid len", http.StatusBadRequest)
+			return
+		}
+		name := q.Get("charset")
+		if name == "" {
+			name = "alnum"
+		}
+		charset, ok := randomCharsets[name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown charset %q", name), http.StatusBadRequest)
+			return
+		}
+
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = charset[rand.N(len(charset))]
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(append(b, '\n'))
+	}
+}
+
+// httpDelay waits for the duration in the path, given as a number of seconds
+// or a duration such as 1500ms and capped at max, or maxEndpointDelay when max
+// is zero, then reflects the request like /anything.
+func httpDelay(max time.Duration, ips *clientIPResolver) http.HandlerFunc {
+	anything := httpAnything(ips)
+	if max <= 0 {
+		max = maxEndpointDelay
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, err := parseDelay(r.PathValue("n"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !sleep(r, min(d, max)) {
+			return
+		}
+		anything(w, r)
+	}
+}
+
+// httpRedirect redirects every request to target with the given status code.
+func httpRedirect(target string, code int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target, code)
+	}
+}
+
+// httpRedirectTo redirects to ?url= with ?status=, default 302, in the style
+// of httpbin's /redirect-to endpoint.
+func httpRedirectTo() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		target := q.Get("url")
+		if target == "" {
+			http.Error(w, "missing url", http.StatusBadRequest)
+			return
+		}
+		code, err := queryInt(q, "status", http.StatusFound)
+		if err != nil || !isRedirect(code) {
+			http.Error(w, "invalid status", http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, target, code)
+	}
+}
+
+// httpRedirectChain redirects n times, through /redirect/{n-1}, before
+// landing on / with the echo response.
+func httpRedirectChain() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(r.PathValue("n"))
+		if err != nil || n < 1 {
+			http.Error(w, "invalid number of redirects", http.StatusBadRequest)
+			return
+		}
+		target := "/"
+		if n > 1 {
+			target = "/redirect/" + strconv.Itoa(n-1)
+		}
+		http.Redirect(w, r, target, http.StatusFound)
+	}
+}
+
+// isRedirect reports whether code is a 3xx status code.
+func isRedirect(code int) bool {
+	return code >= 300 && code < 400
+}
+
+// httpCookies reflects the request cookies as a JSON document.
+func httpCookies() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookies := make(map[string]string)
+		for _, c := range r.Cookies() {
+			cookies[c.Name] = c.Value
+		}
+		writeJSON(w, map[string]any{"cookies": cookies})
+	}
+}
+
+// httpSetCookies sets a cookie for every query parameter, then redirects to
+// /cookies to show the result.
+func httpSetCookies() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for name, values := range r.URL.Query() {
+			http.SetCookie(w, &http.Cookie{Name: name, Value: values[0], Path: "/"})
+		}
+		http.Redirect(w, r, "/cookies", http.StatusFound)
+	}
+}
+
+// httpDeleteCookies expires the cookies named in the query parameters, then
+// redirects to /cookies to show the result.
+func httpDeleteCookies() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for name := range r.URL.Query() {
+			http.SetCookie(w, &http.Cookie{Name: name, Path: "/", MaxAge: -1})
+		}
+		http.Redirect(w, r, "/cookies", http.StatusFound)
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// delayHeader and delayParam let a request ask for its own delay.
+const (
+	delayHeader = "X-Echo-Delay"
+	delayParam  = "delay"
+)
+
+// maxEndpointDelay caps the wait of /delay/{n} when -max-delay is not set.
+const maxEndpointDelay = 10 * time.Second
+
+// withDelay holds every response back for d plus a random duration of up to
+// jitter. When max is positive, a request can ask for a different delay of up
+// to max with the X-Echo-Delay header or the delay query parameter. The wait
+// ends early when the client goes away.
+func withDelay(d, jitter, max time.Duration, h http.HandlerFunc) http.HandlerFunc {
+	if d <= 0 && jitter <= 0 && max <= 0 {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		wait := d
+		if jitter > 0 {
+			wait += rand.N(jitter)
+		}
+		if max > 0 {
+			if requested, ok := requestedDelay(r); ok {
+				wait = min(requested, max)
+			}
+		}
+		if !sleep(r, wait) {
+			return
+		}
+		h(w, r)
+	}
+}
+
+// withErrors fails the given fraction of requests with the status code
+// instead of serving them.
+func withErrors(rate float64, status int, h http.HandlerFunc) http.HandlerFunc {
+	if rate <= 0 {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rand.Float64() < rate {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// withResets aborts the given fraction of requests by resetting the
+// connection instead of serving them.
+func withResets(rate float64, h http.HandlerFunc) http.HandlerFunc {
+	if rate <= 0 {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rand.Float64() < rate {
+			resetConnection(w)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// httpReset resets the connection without sending a response.
+func httpReset() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resetConnection(w)
+	}
+}
+
+// resetConnection takes over the connection and closes it with SO_LINGER set
+// to zero, so the client receives a TCP RST. Connections that cannot be taken
+// over, such as HTTP/2 streams, are aborted by the server instead.
+func resetConnection(w http.ResponseWriter) {
+	c, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	if tc, ok := tcpConnOf(c); ok {
+		tc.SetLinger(0)
+	}
+	c.Close()
+}
+
+// defaultTruncateSize is the announced body size of /truncate responses.
+const defaultTruncateSize = 1024
+
+// httpTruncate announces a body of ?size= bytes, default 1024, but sends only
+// the first half of it before dropping the connection, so clients see a
+// response shorter than its Content-Length.
+func httpTruncate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		size := defaultTruncateSize
+		if v := r.URL.Query().Get("size"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 2 {
+				http.Error(w, fmt.Sprintf("invalid size %q", v), http.StatusBadRequest)
+				return
+			}
+			size = n
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		// Keeps withCompression from dropping the Content-Length that the
+		// truncated body falls short of.
+		w.Header().Set("Content-Encoding", "identity")
+		w.Header().Set("Content-Length", strconv.Itoa(size))
+		w.Write(bytes.Repeat([]byte("x"), size/2))
+		http.NewResponseController(w).Flush()
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// statusHeader and statusParam let a request pick its response status code.
+const (
+	statusHeader = "X-Echo-Status"
+	statusParam  = "status"
+)
+
+// withStatusOverride lets a request replace the response status code with the
+// X-Echo-Status header or the status query parameter.
+func withStatusOverride(allow bool, h http.HandlerFunc) http.HandlerFunc {
+	if !allow {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := r.Header.Get(statusHeader)
+		if v == "" {
+			v = r.URL.Query().Get(statusParam)
+		}
+		if v == "" {
+			h(w, r)
+			return
+		}
+
+		code, err := strconv.Atoi(v)
+		if err != nil || code < 200 || code > 599 {
+			http.Error(w, fmt.Sprintf("invalid status %q", v), http.StatusBadRequest)
+			return
+		}
+		h(&overrideStatusWriter{ResponseWriter: w, status: code}, r)
+	}
+}
+
+// overrideStatusWriter sends its status code in place of the one chosen by
+// the handler.
+type overrideStatusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+// WriteHeader implements the http.ResponseWriter interface.
+func (w *overrideStatusWriter) WriteHeader(code int) {
+	if code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// Write implements the http.ResponseWriter interface.
+func (w *overrideStatusWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(w.status)
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the wrapped writer for use by http.ResponseController.
+func (w *overrideStatusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// requestedDelay returns the delay asked for by the request, given as a
+// duration such as 2s or a number of seconds. Values that do not parse are
+// ignored, as the parameter may mean something else to the application.
+func requestedDelay(r *http.Request) (time.Duration, bool) {
+	v := r.Header.Get(delayHeader)
+	if v == "" {
+		v = r.URL.Query().Get(delayParam)
+	}
+	if v == "" {
+		return 0, false
+	}
+
+	d, err := parseDelay(v)
+	if err != nil {
+		return 0, false
+	}
+	return d, true
+}
+
+// parseDelay parses a duration such as 2s, or a number of seconds.
+func parseDelay(v string) (time.Duration, error) {
+	if secs, err := strconv.ParseFloat(v, 64); err == nil {
+		v = strconv.FormatFloat(secs, 'f', -1, 64) + "s"
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid delay %q", v)
+	}
+	return d, nil
+}
+
+// sleep waits for d, returning false if the request was canceled first.
+func sleep(r *http.Request, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
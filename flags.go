@@ -0,0 +1,39 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"strings"
+)
+
+// stringSliceFlag is a flag.Value that collects every occurrence of a
+// repeatable flag.
+type stringSliceFlag []string
+
+// String implements the flag.Value interface.
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ", ")
+}
+
+// Set implements the flag.Value interface.
+func (s *stringSliceFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// Reset clears the collected values.
+func (s *stringSliceFlag) Reset() {
+	*s = nil
+}
+
+// splitList splits a comma separated flag value, dropping empty entries.
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
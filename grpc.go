@@ -0,0 +1,234 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/http-echo/echopb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// grpcContentType prefixes the content type of gRPC requests, which may also
+// be sent as application/grpc+proto and the like.
+const grpcContentType = "application/grpc"
+
+// grpcHealthPrefix prefixes the paths of gRPC health checks.
+const grpcHealthPrefix = "/grpc.health.v1.Health/"
+
+// maxGRPCStreamResponses caps the number of responses sent by ServerStream.
+const maxGRPCStreamResponses = 1000
+
+// newGRPCServer returns a gRPC server offering the Echo service, the health
+// checking protocol and server reflection, so tools such as grpcurl can call
+// it without the proto file. Readiness depends on the given checks. When conf
+// is not nil, connections accepted by Serve must use TLS.
+func newGRPCServer(conf *tls.Config, checks []readyCheck) *grpc.Server {
+	var opts []grpc.ServerOption
+	if conf != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(conf)))
+	}
+	s := grpc.NewServer(opts...)
+
+	hostname, _ := os.Hostname()
+	echopb.RegisterEchoServer(s, &echoServer{hostname: hostname})
+	healthpb.RegisterHealthServer(s, &grpcHealthServer{
+		services: map[string]func() bool{
+			"":                                  health.Healthy,
+			echopb.Echo_ServiceDesc.ServiceName: health.Healthy,
+			"livez":                             health.Live,
+			"readyz":                            readiness(checks),
+		},
+	})
+	reflection.Register(s)
+	return s
+}
+
+// withGRPC hands HTTP/2 requests with a gRPC content type to s, the gRPC
+// server behind any access checks, so it can share the HTTP listeners.
+func withGRPC(s http.Handler, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), grpcContentType) {
+			s.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// shutdownGRPC stops the gRPC server gracefully, cancelling the calls still
+// running when the context expires.
+func shutdownGRPC(ctx context.Context, s *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.Stop()
+	}
+}
+
+// echoServer implements the Echo service.
+type echoServer struct {
+	echopb.UnimplementedEchoServer
+	hostname string
+}
+
+// Echo implements the Echo service.
+func (s *echoServer) Echo(ctx context.Context, req *echopb.EchoRequest) (*echopb.EchoResponse, error) {
+	return s.response(ctx, req.GetMessage(), 0), nil
+}
+
+// ServerStream implements the Echo service.
+func (s *echoServer) ServerStream(req *echopb.EchoRequest, stream grpc.ServerStreamingServer[echopb.EchoResponse]) error {
+	count := req.GetCount()
+	if count == 0 {
+		count = 10
+	}
+	if count > maxGRPCStreamResponses {
+		return status.Errorf(codes.InvalidArgument, "count must not exceed %d", maxGRPCStreamResponses)
+	}
+	interval := time.Duration(req.GetIntervalMs()) * time.Millisecond
+
+	ctx := stream.Context()
+	for i := range count {
+		if i > 0 && interval > 0 {
+			t := time.NewTimer(interval)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return status.FromContextError(ctx.Err()).Err()
+			}
+		}
+		if err := stream.Send(s.response(ctx, req.GetMessage(), i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BidiStream implements the Echo service.
+func (s *echoServer) BidiStream(stream grpc.BidiStreamingServer[echopb.EchoRequest, echopb.EchoResponse]) error {
+	for i := uint32(0); ; i++ {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(s.response(stream.Context(), req.GetMessage(), i)); err != nil {
+			return err
+		}
+	}
+}
+
+// response builds the response to a message, describing the call it was
+// received on.
+func (s *echoServer) response(ctx context.Context, msg string, seq uint32) *echopb.EchoResponse {
+	resp := &echopb.EchoResponse{
+		Message:  msg,
+		Sequence: seq,
+		Hostname: s.hostname,
+		Metadata: make(map[string]string),
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		resp.Peer = p.Addr.String()
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for k, v := range md {
+		resp.Metadata[k] = strings.Join(v, ", ")
+	}
+	return resp
+}
+
+// grpcHealthWatchInterval is how often Watch re-evaluates the health.
+const grpcHealthWatchInterval = time.Second
+
+// grpcHealthServer implements the gRPC health checking protocol on top of the
+// state behind /health, /livez and /readyz, so toggling them through the admin
+// API affects both. The empty service name, which Kubernetes probes use by
+// default, and httpecho.v1.Echo follow the health check; livez and readyz
+// follow the probes of the same name.
+type grpcHealthServer struct {
+	healthpb.UnimplementedHealthServer
+	services map[string]func() bool
+}
+
+// Check implements the Health service.
+func (s *grpcHealthServer) Check(_ context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	ok, found := s.services[req.GetService()]
+	if !found {
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", req.GetService())
+	}
+	return &healthpb.HealthCheckResponse{Status: servingStatus(ok())}, nil
+}
+
+// List implements the Health service.
+func (s *grpcHealthServer) List(context.Context, *healthpb.HealthListRequest) (*healthpb.HealthListResponse, error) {
+	resp := &healthpb.HealthListResponse{
+		Statuses: make(map[string]*healthpb.HealthCheckResponse, len(s.services)),
+	}
+	for name, ok := range s.services {
+		resp.Statuses[name] = &healthpb.HealthCheckResponse{Status: servingStatus(ok())}
+	}
+	return resp, nil
+}
+
+// Watch implements the Health service. The status is sent right away and
+// again whenever it changes. Unknown services are reported as such instead
+// of failing, as the protocol requires.
+func (s *grpcHealthServer) Watch(req *healthpb.HealthCheckRequest, stream grpc.ServerStreamingServer[healthpb.HealthCheckResponse]) error {
+	current := func() healthpb.HealthCheckResponse_ServingStatus {
+		ok, found := s.services[req.GetService()]
+		if !found {
+			return healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+		}
+		return servingStatus(ok())
+	}
+
+	t := time.NewTicker(grpcHealthWatchInterval)
+	defer t.Stop()
+	last := healthpb.HealthCheckResponse_ServingStatus(-1)
+	for {
+		if st := current(); st != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+		select {
+		case <-t.C:
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
+}
+
+// servingStatus maps a passing check to SERVING and a failing one to
+// NOT_SERVING.
+func servingStatus(ok bool) healthpb.HealthCheckResponse_ServingStatus {
+	if ok {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
@@ -4,9 +4,17 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/http-echo/version"
@@ -20,16 +28,250 @@ const (
 	httpLogFormat     string = "%v %s %s \"%s %s %s\" %d %d \"%s\" %v\n"
 )
 
-// withAppHeaders adds application headers such as X-App-Version and X-App-Name.
-func withAppHeaders(c int, h http.HandlerFunc) http.HandlerFunc {
+// withAppHeaders adds application headers such as X-App-Version and X-App-Name,
+// followed by any extra headers configured with -header. The response is sent
+// with status code c unless the handler writes its own.
+func withAppHeaders(c int, extra http.Header, h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set(httpHeaderAppName, version.Name)
 		w.Header().Set(httpHeaderAppVersion, version.Version)
-		w.WriteHeader(c)
+		for k, v := range extra {
+			w.Header()[k] = append(w.Header()[k], v...)
+		}
+
+		sw := &statusResponseWriter{ResponseWriter: w, status: c}
+		h(sw, r)
+		if !sw.wroteHeader {
+			sw.WriteHeader(c)
+		}
+	}
+}
+
+// parseHeaders parses "Name: value" pairs as given to -header.
+func parseHeaders(values []string) (http.Header, error) {
+	hdr := make(http.Header)
+	for _, v := range values {
+		name, value, ok := strings.Cut(v, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid header %q, expected \"Name: value\"", v)
+		}
+		hdr.Add(name, strings.TrimSpace(value))
+	}
+	return hdr, nil
+}
+
+// withContentType sets the Content-Type of the response unless ct is empty, in
+// which case it is detected from the body.
+func withContentType(ct string, h http.HandlerFunc) http.HandlerFunc {
+	if ct == "" {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ct)
 		h(w, r)
 	}
 }
 
+// cacheMaxAge returns the max-age directive of a Cache-Control value, or -1
+// when it has none.
+func cacheMaxAge(v string) (int, error) {
+	maxAge := -1
+	for _, d := range strings.Split(v, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+		if !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		n, err := strconv.Atoi(strings.Trim(value, `"`))
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid max-age %q", value)
+		}
+		maxAge = n
+	}
+	return maxAge, nil
+}
+
+// withCacheControl sets the Cache-Control header of the response to v, unless
+// it is empty, along with an Expires header maxAge seconds from now when
+// maxAge is not negative. Handlers can still replace the header, e.g. with
+// withNoStore.
+func withCacheControl(v string, maxAge int, h http.HandlerFunc) http.HandlerFunc {
+	if v == "" {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", v)
+		if maxAge >= 0 {
+			w.Header().Set("Expires", time.Now().Add(time.Duration(maxAge)*time.Second).UTC().Format(http.TimeFormat))
+		}
+		h(w, r)
+	}
+}
+
+// withNoStore tells caches along the path not to store the response.
+func withNoStore(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Del("Expires")
+		h(w, r)
+	}
+}
+
+// securityHeaders are the values sent by withSecurityHeaders, on top of the
+// Content-Security-Policy.
+var securityHeaders = map[string]string{
+	"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+	"X-Content-Type-Options":    "nosniff",
+	"X-Frame-Options":           "DENY",
+	"Referrer-Policy":           "no-referrer",
+}
+
+// withSecurityHeaders adds the common security headers and the given
+// Content-Security-Policy to the response, unless enabled is false. Headers
+// already set, e.g. by -header, are kept.
+func withSecurityHeaders(enabled bool, csp string, h http.HandlerFunc) http.HandlerFunc {
+	if !enabled {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range securityHeaders {
+			if w.Header().Get(k) == "" {
+				w.Header().Set(k, v)
+			}
+		}
+		if csp != "" && w.Header().Get("Content-Security-Policy") == "" {
+			w.Header().Set("Content-Security-Policy", csp)
+		}
+		h(w, r)
+	}
+}
+
+// withResponseSize pads or cuts the response body to exactly n bytes, unless
+// n is zero. Padding is made of spaces.
+func withResponseSize(n int, h http.HandlerFunc) http.HandlerFunc {
+	if n <= 0 {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(n))
+		sw := &sizeResponseWriter{ResponseWriter: w, remaining: n}
+		h(sw, r)
+		sw.Write(bytes.Repeat([]byte(" "), sw.remaining))
+	}
+}
+
+// sizeResponseWriter drops everything written beyond its remaining size.
+type sizeResponseWriter struct {
+	http.ResponseWriter
+	remaining int
+}
+
+// Write implements the http.ResponseWriter interface.
+func (w *sizeResponseWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	b = b[:min(len(b), w.remaining)]
+	if len(b) == 0 {
+		return n, nil
+	}
+	w.remaining -= len(b)
+	if _, err := w.ResponseWriter.Write(b); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Unwrap returns the wrapped writer for use by http.ResponseController.
+func (w *sizeResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// statusResponseWriter delays writing the status code until the first write
+// so handlers can still add headers, or replace the status code entirely.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+// WriteHeader implements the http.ResponseWriter interface.
+func (w *statusResponseWriter) WriteHeader(s int) {
+	if s < 200 && s != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(s)
+		return
+	}
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(s)
+}
+
+// Write implements the http.ResponseWriter interface.
+func (w *statusResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(w.status)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Hijack implements the http.Hijacker interface. The connection is taken
+// over as is, so no status code must be written afterwards.
+func (w *statusResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.wroteHeader = true
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the wrapped writer for use by http.ResponseController.
+func (w *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// withMaxBody rejects requests whose body exceeds n bytes with a 413. The body
+// is read up front so the limit is enforced even if the handler never reads
+// it. A limit of zero or less disables the check.
+func withMaxBody(n int64, h http.HandlerFunc) http.HandlerFunc {
+	if n <= 0 {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > n {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, n))
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			} else {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			}
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		h(w, r)
+	}
+}
+
+// overflowKey is the context key marking requests received on a connection
+// accepted beyond the connection limit.
+type overflowKey struct{}
+
+// withOverflowReject answers requests on connections accepted beyond the
+// connection limit with a 503 and closes the connection.
+func withOverflowReject(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Context().Value(overflowKey{}) != nil {
+			w.Header().Set("Connection", "close")
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
 // metaResponseWriter is a response writer that saves information about the
 // response for logging.
 type metaResponseWriter struct {
@@ -54,28 +296,70 @@ func (w *metaResponseWriter) Write(b []byte) (int, error) {
 	if w.status == 0 {
 		w.status = http.StatusOK
 	}
-	w.length = len(b)
+	w.length += len(b)
 	return w.writer.Write(b)
 }
 
-// httpLog accepts an io object and logs the request and response objects to the
-// given io.Writer.
-func httpLog(out io.Writer, h http.HandlerFunc) http.HandlerFunc {
+// Unwrap returns the wrapped writer for use by http.ResponseController.
+func (w *metaResponseWriter) Unwrap() http.ResponseWriter {
+	return w.writer
+}
+
+// httpLog logs the request and response objects to the given access logger.
+func httpLog(l *accessLogger, h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var mrw metaResponseWriter
 		mrw.writer = w
 
 		defer func(start time.Time) {
-			status := mrw.status
-			length := mrw.length
 			end := time.Now()
-			dur := end.Sub(start)
-			fmt.Fprintf(out, httpLogFormat,
-				end.Format(httpLogDateFormat),
-				r.Host, r.RemoteAddr, r.Method, r.URL.Path, r.Proto,
-				status, length, r.UserAgent(), dur)
+			l.Log(&accessLogEntry{
+				Time:     end,
+				Request:  r,
+				Status:   mrw.status,
+				Bytes:    mrw.length,
+				Duration: end.Sub(start),
+			})
 		}(time.Now())
 
 		h(&mrw, r)
 	}
 }
+
+// withRequestLimit calls reached once n requests have been served by h,
+// including those aborted on purpose such as /truncate. Requests for the
+// given probe and metrics paths, and gRPC health checks, are not counted, so
+// an orchestrator does not use up the limit. Requests arriving afterwards are
+// still served while the server shuts down.
+func withRequestLimit(n int64, probes []string, reached func(), h http.Handler) http.Handler {
+	var served atomic.Int64
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(probes, r.URL.Path) || strings.HasPrefix(r.URL.Path, grpcHealthPrefix) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		defer func() {
+			if served.Add(1) == n {
+				reached()
+			}
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// swapHandler serves requests with a handler that can be replaced while the
+// server is running. Requests already in flight finish with the handler they
+// started with.
+type swapHandler struct {
+	h atomic.Pointer[http.Handler]
+}
+
+// Store replaces the handler used for new requests.
+func (s *swapHandler) Store(h http.Handler) {
+	s.h.Store(&h)
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (s *swapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*s.h.Load()).ServeHTTP(w, r)
+}
@@ -0,0 +1,224 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// healthState holds the health, liveness and readiness of the process. They
+// are kept apart so the process can be live but not ready, e.g. while warming
+// up or draining. The state outlives handler reloads.
+type healthState struct {
+	healthy atomic.Bool
+	live    atomic.Bool
+	ready   atomic.Bool
+
+	// readyAt is the Unix time in nanoseconds before which the process is
+	// not ready, as it is still warming up.
+	readyAt atomic.Int64
+
+	// failAt is the Unix time in nanoseconds from which the health check and
+	// liveness fail, zero for never.
+	failAt atomic.Int64
+}
+
+// health is the state reported by /livez and /readyz.
+var health = newHealthState()
+
+// newHealthState returns a state that is healthy, live and ready.
+func newHealthState() *healthState {
+	s := &healthState{}
+	s.healthy.Store(true)
+	s.live.Store(true)
+	s.ready.Store(true)
+	return s
+}
+
+// Healthy reports whether the health check passes.
+func (s *healthState) Healthy() bool {
+	return s.healthy.Load() && !s.expired()
+}
+
+// Live reports whether the process is live.
+func (s *healthState) Live() bool {
+	return s.live.Load() && !s.expired()
+}
+
+// expired reports whether the time set with FailAfter has passed.
+func (s *healthState) expired() bool {
+	at := s.failAt.Load()
+	return at != 0 && time.Now().UnixNano() >= at
+}
+
+// Ready reports whether the process is ready to serve traffic.
+func (s *healthState) Ready() bool {
+	return s.ready.Load() && time.Now().UnixNano() >= s.readyAt.Load()
+}
+
+// WarmUp keeps the process from being ready for the given duration.
+func (s *healthState) WarmUp(d time.Duration) {
+	s.readyAt.Store(time.Now().Add(d).UnixNano())
+}
+
+// FailAfter makes the health check and liveness fail once the given duration
+// has passed, unless it is zero.
+func (s *healthState) FailAfter(d time.Duration) {
+	if d > 0 {
+		s.failAt.Store(time.Now().Add(d).UnixNano())
+	}
+}
+
+// SetReady marks the process as ready or not.
+func (s *healthState) SetReady(v bool) {
+	s.ready.Store(v)
+}
+
+// probe returns the state behind the named endpoint: health, livez or
+// readyz.
+func (s *healthState) probe(name string) (*atomic.Bool, bool) {
+	switch name {
+	case "health":
+		return &s.healthy, true
+	case "livez":
+		return &s.live, true
+	case "readyz":
+		return &s.ready, true
+	}
+	return nil, false
+}
+
+// httpHealth responds to health checks with the given body while ok reports
+// true, and with a 503 otherwise.
+func httpHealth(body string, ok func() bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ok() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, `{"status":"unavailable"}`)
+			return
+		}
+		fmt.Fprintln(w, body)
+	}
+}
+
+// httpProbe responds with a 200 while ok reports true and a 503 otherwise, for
+// the /livez and /readyz probes.
+func httpProbe(ok func() bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ok() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, `{"status":"unavailable"}`)
+			return
+		}
+		fmt.Fprintln(w, `{"status":"ok"}`)
+	}
+}
+
+// readyCheckTimeout bounds the -ready-check dependencies, which are checked
+// concurrently.
+const readyCheckTimeout = 2 * time.Second
+
+// readyCheck is a dependency that must be reachable for the process to be
+// ready: a tcp://host:port address that accepts connections, or an http(s)://
+// URL that answers with a 2xx or 3xx.
+type readyCheck struct {
+	url *url.URL
+}
+
+// parseReadyCheck parses a -ready-check value.
+func parseReadyCheck(v string) (readyCheck, error) {
+	u, err := url.Parse(v)
+	if err != nil {
+		return readyCheck{}, err
+	}
+	switch u.Scheme {
+	case "tcp", "http", "https":
+	default:
+		return readyCheck{}, fmt.Errorf("unsupported check %q, expected tcp://, http:// or https://", v)
+	}
+	if u.Host == "" {
+		return readyCheck{}, fmt.Errorf("missing host in check %q", v)
+	}
+	return readyCheck{url: u}, nil
+}
+
+// Check reports why the dependency is unreachable, or nil when it is fine. It
+// gives up once the context is done.
+func (c readyCheck) Check(ctx context.Context) error {
+	if c.url.Scheme == "tcp" {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", c.url.Host)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// parseReadyChecks parses the -ready-check values.
+func parseReadyChecks(values []string) ([]readyCheck, error) {
+	var checks []readyCheck
+	for _, v := range values {
+		c, err := parseReadyCheck(v)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, c)
+	}
+	return checks, nil
+}
+
+// readiness returns a function reporting whether the process is ready and
+// every check passes. The checks run concurrently, so a probe takes at most
+// readyCheckTimeout however many dependencies are down. Failing checks are
+// logged.
+func readiness(checks []readyCheck) func() bool {
+	return func() bool {
+		if !health.Ready() {
+			return false
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), readyCheckTimeout)
+		defer cancel()
+		var wg sync.WaitGroup
+		var failed atomic.Bool
+		for _, c := range checks {
+			wg.Go(func() {
+				if err := c.Check(ctx); err != nil {
+					log.Printf("[WARN] readiness check %s failed: %s", c.url, err)
+					failed.Store(true)
+				}
+			})
+		}
+		wg.Wait()
+		return !failed.Load()
+	}
+}
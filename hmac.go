@@ -0,0 +1,100 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// hmacPrefix is the optional prefix of signatures, as sent by GitHub and
+// others.
+const hmacPrefix = "sha256="
+
+// maxSignedBodySize caps the size of response bodies buffered for signing.
+const maxSignedBodySize = 10 << 20
+
+// withHMACVerify requires requests to carry the HMAC-SHA256 of their body,
+// keyed with secret, in the given header. The signature may be hex or base64
+// encoded and prefixed with "sha256=". Requests with a missing or wrong
+// signature are answered with a 401. An empty secret leaves requests
+// untouched.
+func withHMACVerify(secret, header string, h http.HandlerFunc) http.HandlerFunc {
+	if secret == "" {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		sig, ok := decodeSignature(r.Header.Get(header))
+		if !ok || !hmac.Equal(sig, signHMAC(secret, body)) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// signHMAC returns the HMAC-SHA256 of body keyed with secret.
+func signHMAC(secret string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// decodeSignature decodes a hex or base64 signature, with or without the
+// "sha256=" prefix.
+func decodeSignature(v string) ([]byte, bool) {
+	v = strings.TrimSpace(v)
+	v = strings.TrimPrefix(v, hmacPrefix)
+	if v == "" {
+		return nil, false
+	}
+	if b, err := hex.DecodeString(v); err == nil {
+		return b, true
+	}
+	if b, err := base64.StdEncoding.DecodeString(v); err == nil {
+		return b, true
+	}
+	if b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(v, "=")); err == nil {
+		return b, true
+	}
+	return nil, false
+}
+
+// withResponseSigning adds the HMAC-SHA256 of the response body, keyed with
+// secret, to the given header as a hex string prefixed with "sha256=". The
+// body is buffered until complete, so it must not wrap streaming endpoints,
+// proxied responses or connection upgrades. Bodies over maxSignedBodySize are
+// answered with a 500. An empty secret leaves responses untouched.
+func withResponseSigning(secret, header string, h http.HandlerFunc) http.HandlerFunc {
+	if secret == "" {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		bw := &bufferResponseWriter{ResponseWriter: w, limit: maxSignedBodySize}
+		h(bw, r)
+		if bw.overflow {
+			log.Printf("[ERR] response to %s %s is too large to sign", r.Method, r.URL.Path)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if r.Method != http.MethodHead {
+			w.Header().Set(header, hmacPrefix+hex.EncodeToString(signHMAC(secret, bw.buf.Bytes())))
+		}
+		bw.send()
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newHTTP3Server returns an HTTP/3 server for the given UDP address that
+// serves the same handler as the TCP listener.
+func newHTTP3Server(addr string, h http.Handler, tlsConf *tls.Config) *http3.Server {
+	return &http3.Server{
+		Addr:           addr,
+		Handler:        h,
+		TLSConfig:      http3.ConfigureTLSConfig(tlsConf),
+		IdleTimeout:    *idleTimeoutFlag,
+		MaxHeaderBytes: *maxHeaderBytesFlag,
+	}
+}
+
+// withAltSvc advertises the HTTP/3 server to clients of the TCP listener
+// through the Alt-Svc header.
+func withAltSvc(s *http3.Server, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.SetQUICHeaders(w.Header())
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,201 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+const (
+	// jwksRefreshInterval is how long fetched keys are used before the key set
+	// is fetched again.
+	jwksRefreshInterval = 5 * time.Minute
+
+	// jwksMinRefreshInterval limits how often a token signed with an unknown
+	// key can trigger a fetch.
+	jwksMinRefreshInterval = 10 * time.Second
+
+	// jwtClaimsHeader carries the verified claims with -jwt-echo-claims.
+	jwtClaimsHeader = "X-Jwt-Claims"
+)
+
+// jwtAlgorithms are the signature algorithms accepted on bearer tokens.
+var jwtAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// jwtVerifier validates bearer tokens against the keys published at a JWKS
+// URL, and optionally the issuer and audience they were issued for.
+type jwtVerifier struct {
+	url      string
+	issuer   string
+	audience []string
+	client   *http.Client
+
+	mu        sync.Mutex
+	keys      *jose.JSONWebKeySet
+	fetched   time.Time
+	attempted time.Time
+	fetchErr  error
+}
+
+// newJWTVerifier returns a verifier for the keys at url. It returns nil when
+// url is empty.
+func newJWTVerifier(url, issuer, audience string) (*jwtVerifier, error) {
+	if url == "" {
+		if issuer != "" || audience != "" {
+			return nil, errors.New("-jwt-issuer and -jwt-audience require -jwt-jwks-url")
+		}
+		return nil, nil
+	}
+	return &jwtVerifier{
+		url:      url,
+		issuer:   issuer,
+		audience: splitList(audience),
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// errJWTForbidden is returned for valid tokens issued for someone else.
+var errJWTForbidden = errors.New("token not issued for this service")
+
+// Verify checks the signature and lifetime of the token, then its issuer and
+// audience, returning its claims. Tokens failing the latter check yield
+// errJWTForbidden.
+func (v *jwtVerifier) Verify(token string) (map[string]any, error) {
+	tok, err := jwt.ParseSigned(token, jwtAlgorithms)
+	if err != nil {
+		return nil, err
+	}
+	kid := tok.Headers[0].KeyID
+	keys, err := v.key(kid)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	var claims jwt.Claims
+	var all map[string]any
+	if err := tok.Claims(keys[0].Key, &claims, &all); err != nil {
+		return nil, err
+	}
+	if err := claims.Validate(jwt.Expected{}); err != nil {
+		return nil, err
+	}
+	if err := claims.Validate(jwt.Expected{Issuer: v.issuer, AnyAudience: v.audience}); err != nil {
+		return nil, fmt.Errorf("%w: %w", errJWTForbidden, err)
+	}
+	return all, nil
+}
+
+// key returns the keys with the given ID, fetching the key set when it is
+// stale or does not contain the key yet. Fetches are throttled on the last
+// attempt, so an unreachable JWKS URL fails requests fast instead of holding
+// every one of them up.
+func (v *jwtVerifier) key(kid string) ([]jose.JSONWebKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var keys []jose.JSONWebKey
+	if v.keys != nil {
+		keys = v.keys.Key(kid)
+	}
+	age := time.Since(v.fetched)
+	if v.keys != nil && age < jwksRefreshInterval && (len(keys) > 0 || age < jwksMinRefreshInterval) {
+		return keys, nil
+	}
+	if time.Since(v.attempted) < jwksMinRefreshInterval {
+		if v.keys == nil {
+			return nil, fmt.Errorf("JWKS not available: %w", v.fetchErr)
+		}
+		return keys, nil
+	}
+
+	v.attempted = time.Now()
+	set, err := v.fetch()
+	v.fetchErr = err
+	if err != nil {
+		if v.keys == nil {
+			return nil, err
+		}
+		log.Printf("[WARN] failed to refresh JWKS from %s, using the previous keys: %s", v.url, err)
+		return keys, nil
+	}
+	v.keys = set
+	v.fetched = time.Now()
+	return set.Key(kid), nil
+}
+
+// fetch downloads the key set.
+func (v *jwtVerifier) fetch() (*jose.JSONWebKeySet, error) {
+	resp, err := v.client.Get(v.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var set jose.JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+	return &set, nil
+}
+
+// withJWT requires requests to carry a bearer token accepted by v. Missing or
+// invalid tokens are answered with a 401, tokens for another issuer or
+// audience with a 403. When echo is set, the claims are sent back in the
+// X-Jwt-Claims header as JSON. A nil verifier leaves requests untouched.
+func withJWT(v *jwtVerifier, echo bool, h http.HandlerFunc) http.HandlerFunc {
+	if v == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		token = strings.TrimSpace(token)
+		if !strings.EqualFold(scheme, "Bearer") || token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := v.Verify(token)
+		switch {
+		case errors.Is(err, errJWTForbidden):
+			log.Printf("[DEBUG] rejected bearer token: %s", err)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		case err != nil:
+			log.Printf("[DEBUG] rejected bearer token: %s", err)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		if echo {
+			b, err := json.Marshal(claims)
+			if err == nil {
+				w.Header().Set(jwtClaimsHeader, string(b))
+			}
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pires/go-proxyproto"
+)
+
+// listenerOpts controls how createListener binds and wraps the listening
+// socket.
+type listenerOpts struct {
+	// ProxyProtocol requires a PROXY protocol v1 or v2 header on accepted
+	// connections so the remote address reflects the original client.
+	ProxyProtocol bool
+
+	// SocketMode sets the permissions of a unix domain socket. It is ignored
+	// for TCP listeners and left to the umask when zero.
+	SocketMode fs.FileMode
+
+	// ReusePort sets SO_REUSEPORT so several processes can bind the same
+	// address and share incoming connections.
+	ReusePort bool
+
+	// BindDevice sets SO_BINDTODEVICE so the socket only accepts traffic
+	// arriving on the named network interface.
+	BindDevice string
+
+	// Freebind sets IP_FREEBIND so the listener can bind addresses that are
+	// not (yet) assigned to a local interface.
+	Freebind bool
+
+	// Transparent sets IP_TRANSPARENT so the listener accepts connections
+	// redirected to it by TPROXY, addressed to any destination. It requires
+	// CAP_NET_ADMIN.
+	Transparent bool
+
+	// KeepAlive configures TCP keep-alive probes on accepted connections.
+	// Probes are disabled when Enable is false.
+	KeepAlive net.KeepAliveConfig
+
+	// MaxConns caps the number of concurrently open connections, zero for no
+	// limit. OverflowReject controls what happens at the limit: new
+	// connections are either left in the accept queue or accepted and marked
+	// so their requests are answered with a 503.
+	MaxConns       int
+	OverflowReject bool
+}
+
+// unixPrefix marks a listen address as a unix domain socket path.
+const unixPrefix = "unix://"
+
+// createListener binds the listening socket for the given address. When the
+// process was socket activated, the inherited socket is used instead and
+// nothing is bound. TLS is layered on top by the server itself, so the
+// returned listener always yields raw connections.
+func createListener(addr string, opts listenerOpts) (net.Listener, error) {
+	ln, err := activatedListener()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case ln != nil:
+		log.Printf("[INFO] using inherited listener on %s in place of %s", ln.Addr(), addr)
+	case strings.HasPrefix(addr, unixPrefix):
+		ln, err = listenUnix(strings.TrimPrefix(addr, unixPrefix), opts.SocketMode)
+	default:
+		lc := net.ListenConfig{
+			Control:         opts.control,
+			KeepAliveConfig: opts.KeepAlive,
+		}
+		if !opts.KeepAlive.Enable {
+			lc.KeepAlive = -1
+		}
+		ln, err = lc.Listen(context.Background(), "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.ProxyProtocol {
+		ln = &proxyproto.Listener{Listener: ln}
+	}
+	if opts.MaxConns > 0 {
+		ln = newLimitListener(ln, opts.MaxConns, opts.OverflowReject)
+	}
+
+	return ln, nil
+}
+
+// limitListener caps the number of concurrently open connections of the
+// wrapped listener.
+type limitListener struct {
+	net.Listener
+	sem    chan struct{}
+	reject bool
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// newLimitListener returns a listener allowing at most n open connections.
+// Once the limit is reached, Accept either blocks until a connection is
+// closed, or when reject is set returns an overflowConn.
+func newLimitListener(ln net.Listener, n int, reject bool) *limitListener {
+	return &limitListener{
+		Listener: ln,
+		sem:      make(chan struct{}, n),
+		reject:   reject,
+		done:     make(chan struct{}),
+	}
+}
+
+// Accept implements the net.Listener interface.
+func (l *limitListener) Accept() (net.Conn, error) {
+	if !l.reject {
+		select {
+		case l.sem <- struct{}{}:
+		case <-l.done:
+			return nil, net.ErrClosed
+		}
+		c, err := l.Listener.Accept()
+		if err != nil {
+			<-l.sem
+			return nil, err
+		}
+		return &limitConn{Conn: c, release: l.release}, nil
+	}
+
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case l.sem <- struct{}{}:
+		return &limitConn{Conn: c, release: l.release}, nil
+	default:
+		return &overflowConn{Conn: c}, nil
+	}
+}
+
+// Close implements the net.Listener interface.
+func (l *limitListener) Close() error {
+	err := l.Listener.Close()
+	l.closeOnce.Do(func() { close(l.done) })
+	return err
+}
+
+func (l *limitListener) release() {
+	<-l.sem
+}
+
+// limitConn gives its slot back to the limitListener when closed.
+type limitConn struct {
+	net.Conn
+	releaseOnce sync.Once
+	release     func()
+}
+
+// Close implements the net.Conn interface.
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.releaseOnce.Do(c.release)
+	return err
+}
+
+// overflowConn is a connection accepted beyond the -max-conns limit.
+type overflowConn struct {
+	net.Conn
+}
+
+// isOverflowConn reports whether c, or the connection underneath a TLS
+// connection, was accepted beyond the connection limit.
+func isOverflowConn(c net.Conn) bool {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	_, ok := c.(*overflowConn)
+	return ok
+}
+
+// tcpConnOf returns the TCP connection underneath c, looking through the TLS,
+// PROXY protocol and connection limit wrappers.
+func tcpConnOf(c net.Conn) (*net.TCPConn, bool) {
+	for {
+		switch v := c.(type) {
+		case *net.TCPConn:
+			return v, true
+		case *tls.Conn:
+			c = v.NetConn()
+		case *proxyproto.Conn:
+			c = v.Raw()
+		case *limitConn:
+			c = v.Conn
+		case *overflowConn:
+			c = v.Conn
+		default:
+			return nil, false
+		}
+	}
+}
+
+// rawListener returns the listener underneath ln, looking through the PROXY
+// protocol and connection limit wrappers.
+func rawListener(ln net.Listener) net.Listener {
+	for {
+		switch l := ln.(type) {
+		case *proxyproto.Listener:
+			ln = l.Listener
+		case *limitListener:
+			ln = l.Listener
+		default:
+			return ln
+		}
+	}
+}
+
+// listenUnix binds a unix domain socket at the given path, replacing a stale
+// socket left behind by a previous run.
+func listenUnix(path string, mode fs.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+
+	return ln, nil
+}
@@ -0,0 +1,97 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sync"
+
+	"google.golang.org/grpc"
+)
+
+// liveHandler serves requests with the handler built from the current flag
+// values, and rebuilds it when the settings are changed at runtime through
+// SIGHUP or the admin API.
+type liveHandler struct {
+	swapHandler
+
+	mu sync.Mutex
+	// explicit holds the flags that a config reload must not touch: those
+	// given on the command line or changed through the admin API.
+	explicit map[string]bool
+	stop     chan struct{}
+
+	// accessLog is where every handler writes the access log, so it can be
+	// opened once and survive reloads.
+	accessLog io.Writer
+
+	// grpc is the gRPC server sharing the HTTP listeners, if any.
+	grpc *grpc.Server
+
+	// limits holds the global and per-client rate limit buckets, so reloads
+	// do not refill them.
+	limits rateLimits
+}
+
+// newLiveHandler builds the initial handler.
+func newLiveHandler(explicit map[string]bool, accessLog io.Writer, grpc *grpc.Server) (*liveHandler, error) {
+	l := &liveHandler{explicit: explicit, accessLog: accessLog, grpc: grpc}
+	if err := l.rebuild(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reload re-reads the config file, if any, and swaps in a handler built from
+// it. The current handler is kept if the new settings are invalid.
+func (l *liveHandler) Reload() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if *configFlag != "" {
+		resetFlags(l.explicit)
+		if err := loadConfig(*configFlag, l.explicit); err != nil {
+			return fmt.Errorf("-config: %w", err)
+		}
+	}
+	return l.rebuild()
+}
+
+// Set changes a single flag and swaps in a handler using the new value. The
+// previous value is restored if the handler cannot be built with it.
+func (l *liveHandler) Set(name, value string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f := flag.Lookup(name)
+	old := f.Value.String()
+	if err := f.Value.Set(value); err != nil {
+		return fmt.Errorf("-%s: %w", name, err)
+	}
+	if err := l.rebuild(); err != nil {
+		f.Value.Set(old)
+		return err
+	}
+	l.explicit[name] = true
+	return nil
+}
+
+// rebuild builds a handler from the current flags and swaps it in, stopping
+// the background work of the previous one.
+func (l *liveHandler) rebuild() error {
+	stop := make(chan struct{})
+	h, err := newHandler(stop, l.accessLog, l.grpc, &l.limits)
+	if err != nil {
+		close(stop)
+		return err
+	}
+	l.Store(h)
+	if l.stop != nil {
+		close(l.stop)
+	}
+	l.stop = stop
+	return nil
+}
@@ -0,0 +1,52 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/http-echo/version"
+)
+
+// logger is the leveled logger for server messages. Messages written through
+// the standard log package are routed to it, with the level taken from their
+// [DEBUG], [INFO], [WARN] or [ERR] prefix.
+var logger = hclog.Default()
+
+// setupLogging creates the logger for the given -log-level and redirects the
+// standard log package to it. Messages go to syslog when conn is set, and to
+// stderr otherwise.
+func setupLogging(level string, conn syslogConn) error {
+	l := hclog.LevelFromString(level)
+	if l == hclog.NoLevel {
+		return fmt.Errorf("unknown level %q, expected debug, info, warn or error", level)
+	}
+
+	opts := &hclog.LoggerOptions{
+		Name:   version.Name,
+		Level:  l,
+		Output: stderrW,
+	}
+	if conn != nil {
+		opts.Output = syslogServerWriter{conn: conn}
+		opts.DisableTime = true
+	}
+	logger = hclog.New(opts)
+	log.SetOutput(logger.StandardWriter(&hclog.StandardLoggerOptions{InferLevels: true}))
+	log.SetFlags(0)
+	return nil
+}
+
+// withDebugLog logs the headers of every request at debug level.
+func withDebugLog(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if logger.IsDebug() {
+			logger.Debug("request", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr, "headers", r.Header)
+		}
+		h(w, r)
+	}
+}
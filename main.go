@@ -5,47 +5,243 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"net"
 	"net/http"
+	"net/http/httputil"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/hashicorp/http-echo/version"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 var (
-	listenFlag  = flag.String("listen", ":5678", "address and port to listen")
-	textFlag    = flag.String("text", "", "text to put on the webpage")
-	versionFlag = flag.Bool("version", false, "display version information")
-	statusFlag  = flag.Int("status-code", 200, "http response code, e.g.: 200")
+	listenFlag     stringSliceFlag
+	headerFlag     stringSliceFlag
+	pathFlag       stringSliceFlag
+	basicAuthFlag  stringSliceFlag
+	apiKeyFlag     stringSliceFlag
+	allowCIDRFlag  stringSliceFlag
+	denyCIDRFlag   stringSliceFlag
+	readyCheckFlag stringSliceFlag
+	textFlag       = flag.String("text", "", "text to put on the webpage")
+	configFlag     = flag.String("config", "", "HCL, JSON or YAML file with settings named after the flags, flags take precedence")
+	versionFlag    = flag.Bool("version", false, "display version information")
+	statusFlag     = flag.Int("status-code", 200, "http response code, e.g.: 200")
+	methodsFlag    = flag.String("methods", "", "comma separated methods accepted on every route, others get a 405, all when empty")
+
+	textFileFlag         = flag.String("text-file", "", "file to read the text to put on the webpage from, reloaded when it changes")
+	textFileIntervalFlag = flag.Duration("text-file-interval", 2*time.Second, "how often to check -text-file for changes, 0 to disable reloading")
+	templateFlag         = flag.Bool("template", false, "parse -text as a Go template with access to the request, e.g.: {{.Method}} {{.Path}}")
+	contentTypeFlag      = flag.String("content-type", "", "Content-Type of the text, e.g.: application/json, detected from the text when empty")
+	serveDirFlag         = flag.String("serve-dir", "", "directory to serve static files from")
+	serveDirPrefixFlag   = flag.String("serve-dir-prefix", "/", "URL path prefix to serve -serve-dir under, / replaces the text")
+	responseSizeFlag     = flag.Int("response-size", 0, "pad or cut the text response to exactly this many bytes, 0 to send it as is")
+	negotiateFlag        = flag.Bool("negotiate", false, "render the text as plain text, JSON, XML or HTML according to the Accept header")
+	allowedMethodsFlag   = flag.String("allowed-methods", "", "comma separated methods accepted by the text routes, all common methods when empty")
+	cacheControlFlag     = flag.String("cache-control", "", "Cache-Control header to send with responses, e.g.: \"public, max-age=60\", with a matching Expires header")
+	conditionalFlag      = flag.Bool("conditional", false, "send an ETag and Last-Modified with the text and answer matching conditional requests with 304")
+	redirectToFlag       = flag.String("redirect-to", "", "URL to redirect requests for the text to instead of serving it")
+	redirectStatusFlag   = flag.Int("redirect-status", http.StatusFound, "status code for -redirect-to, e.g.: 301")
+	echoRequestFlag      = flag.Bool("echo-request", false, "include the incoming request line, headers and body in the response")
+	mirrorToFlag         = flag.String("mirror-to", "", "URL to asynchronously send a copy of every request to, discarding the responses, e.g.: http://shadow:8080")
+	proxyUpstreamFlag    = flag.String("proxy-upstream", "", "URL to forward every request to in place of the text and built-in endpoints, logging each exchange, e.g.: http://backend:8080")
+
+	enableMetricsFlag = flag.Bool("enable-metrics", false, "expose Prometheus metrics about the served requests on /metrics")
+	statsdAddrFlag    = flag.String("statsd-addr", "", "host:port of a StatsD or DogStatsD server to send request counters and timings to over UDP")
+	statsdPrefixFlag  = flag.String("statsd-prefix", "http_echo", "prefix for the names of the metrics sent to -statsd-addr")
+	otlpEndpointFlag  = flag.String("otlp-endpoint", "", "OTLP/HTTP endpoint to export a trace span for every request to, e.g.: http://localhost:4318")
+	adminListenFlag   = flag.String("admin-listen", "", "address to serve the admin API on for changing the text and status code at runtime, e.g.: :5679")
+
+	compressFlag        = flag.Bool("compress", false, "compress responses with gzip, deflate or brotli according to the Accept-Encoding header")
+	compressMinSizeFlag = flag.Int("compress-min-size", 1024, "smallest response in bytes to compress with -compress")
+	compressLevelFlag   = flag.Int("compress-level", -1, "compression level from 1 to 9 for -compress, -1 for the default")
+
+	h2cFlag   = flag.Bool("h2c", false, "accept HTTP/2 with prior knowledge on plaintext connections")
+	http3Flag = flag.Bool("http3", false, "also serve HTTP/3 over QUIC on the UDP port of each TLS listener")
+
+	grpcFlag       = flag.Bool("grpc", false, "also serve the gRPC Echo service on the HTTP listeners, plaintext ones need -h2c")
+	grpcListenFlag = flag.String("grpc-listen", "", "address to serve the gRPC Echo service on, separately from -listen, e.g.: :9090")
+
+	tcpListenFlag      = flag.String("tcp-listen", "", "address to echo raw bytes back on accepted TCP connections, e.g.: :9000")
+	tcpIdleTimeoutFlag = flag.Duration("tcp-idle-timeout", 0, "time after which idle -tcp-listen connections are closed, 0 for no timeout")
+
+	udpListenFlag = flag.String("udp-listen", "", "address to echo UDP datagrams back to their sender on, e.g.: :5678")
+	udpPrefixFlag = flag.String("udp-prefix", "", "text to prepend to every datagram echoed by -udp-listen")
+
+	socketModeFlag    = flag.String("socket-mode", "", "octal permissions for a unix socket listener, e.g.: 0660")
+	reusePortFlag     = flag.Bool("reuseport", false, "set SO_REUSEPORT on TCP listeners so several processes can bind the same port (Linux only)")
+	bindDeviceFlag    = flag.String("bind-device", "", "bind TCP listeners to the named network interface with SO_BINDTODEVICE (Linux only)")
+	freebindFlag      = flag.Bool("freebind", false, "set IP_FREEBIND on TCP listeners to bind addresses not yet assigned to the host (Linux only)")
+	transparentFlag   = flag.Bool("transparent", false, "set IP_TRANSPARENT on listeners to accept connections redirected by TPROXY, needs CAP_NET_ADMIN (Linux only)")
+	proxyProtocolFlag = flag.Bool("proxy-protocol", false, "require a PROXY protocol v1/v2 header on incoming connections and use its client address")
+
+	tlsCertFlag           = flag.String("tls-cert", "", "path to a PEM encoded certificate to serve HTTPS with")
+	tlsKeyFlag            = flag.String("tls-key", "", "path to the PEM encoded private key for -tls-cert")
+	tlsReloadIntervalFlag = flag.Duration("tls-reload-interval", 0, "how often to check -tls-cert and -tls-key for changes, 0 to only reload on SIGHUP")
+
+	tlsMinVersionFlag = flag.String("tls-min-version", "1.2", "minimum TLS version to accept: 1.0, 1.1, 1.2 or 1.3")
+	tlsMaxVersionFlag = flag.String("tls-max-version", "", "maximum TLS version to accept: 1.0, 1.1, 1.2 or 1.3")
+	tlsCiphersFlag    = flag.String("tls-ciphers", "", "comma separated cipher suites to allow for TLS 1.2 and below, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256")
+
+	tlsClientCAFlag          = flag.String("tls-client-ca", "", "path to a PEM encoded CA bundle used to verify client certificates")
+	tlsRequireClientCertFlag = flag.Bool("tls-require-client-cert", false, "reject TLS connections that do not present a valid client certificate")
+
+	tlsSelfSignedFlag      = flag.Bool("tls-self-signed", false, "serve HTTPS with an ephemeral self-signed certificate")
+	tlsSelfSignedHostsFlag = flag.String("tls-self-signed-hosts", "localhost,127.0.0.1,::1", "comma separated hosts for the self-signed certificate, the first is used as the CN")
+
+	acmeDomainFlag    = flag.String("acme-domain", "", "comma separated domains to obtain certificates for via ACME")
+	acmeEmailFlag     = flag.String("acme-email", "", "contact email for the ACME account")
+	acmeCacheDirFlag  = flag.String("acme-cache-dir", "acme-cache", "directory to cache ACME certificates in, empty to keep them in memory")
+	acmeDirectoryFlag = flag.String("acme-directory-url", "", "ACME directory URL, defaults to Let's Encrypt production")
+
+	rateLimitFlag      = flag.Float64("rate-limit", 0, "maximum requests per second across all clients before responding with 429, 0 for no limit")
+	rateLimitBurstFlag = flag.Int("rate-limit-burst", 0, "number of requests allowed in a burst above -rate-limit, 0 for one second worth")
+
+	rateLimitPerIPFlag        = flag.Float64("rate-limit-per-ip", 0, "maximum requests per second from each client IP before responding with 429, 0 for no limit")
+	rateLimitPerIPBurstFlag   = flag.Int("rate-limit-per-ip-burst", 0, "number of requests allowed in a burst above -rate-limit-per-ip, 0 for one second worth")
+	rateLimitPerIPClientsFlag = flag.Int("rate-limit-per-ip-clients", 10000, "number of client IPs to track for -rate-limit-per-ip, least recently seen clients are forgotten first")
+
+	trustedProxiesFlag = flag.String("trusted-proxies", "", "comma separated CIDRs of proxies whose X-Forwarded-For header is trusted to identify the client")
+
+	maxConnsFlag     = flag.Int("max-conns", 0, "maximum number of concurrent connections per listener, 0 for no limit")
+	overflowModeFlag = flag.String("overflow-mode", "queue", "what to do with connections beyond -max-conns: queue or reject with a 503")
+
+	tcpKeepAliveFlag         = flag.Duration("tcp-keepalive", 15*time.Second, "idle time before TCP keep-alive probes are sent, negative to disable keep-alives")
+	tcpKeepAliveIntervalFlag = flag.Duration("tcp-keepalive-interval", 15*time.Second, "time between unanswered TCP keep-alive probes")
+	tcpKeepAliveCountFlag    = flag.Int("tcp-keepalive-count", 9, "unanswered TCP keep-alive probes before the connection is dropped")
+
+	readTimeoutFlag       = flag.Duration("read-timeout", 0, "maximum duration for reading an entire request, including the body, 0 for no timeout")
+	readHeaderTimeoutFlag = flag.Duration("read-header-timeout", 0, "maximum duration for reading request headers, 0 to use -read-timeout")
+	writeTimeoutFlag      = flag.Duration("write-timeout", 0, "maximum duration before timing out writes of the response, 0 for no timeout")
+	idleTimeoutFlag       = flag.Duration("idle-timeout", 0, "maximum time to wait for the next request on a keep-alive connection, 0 to use -read-timeout")
+	maxBodyBytesFlag      = flag.Int64("max-body-bytes", 0, "maximum size of request bodies before responding with 413, 0 for no limit")
+	maxHeaderBytesFlag    = flag.Int("max-header-bytes", 0, "maximum size of request headers before responding with 431, 0 for the default of 1MB")
+
+	logLevelFlag       = flag.String("log-level", "info", "level of the server messages to log: debug, info, warn or error, debug also logs request headers")
+	logFormatFlag      = flag.String("log-format", "text", "format of the access log: text, json, common or combined")
+	logTemplateFlag    = flag.String("log-template", "", "Go template for access log lines in place of -log-format, e.g.: {{.Status}} {{.Method}} {{.Path}} {{.Duration}}")
+	logFileFlag        = flag.String("log-file", "", "file to write the access log to in place of stdout, rotated once it reaches -log-max-size")
+	logMaxSizeFlag     = flag.Int("log-max-size", 100, "size in megabytes at which -log-file is rotated")
+	logMaxBackupsFlag  = flag.Int("log-max-backups", 0, "number of rotated -log-file files to keep, 0 to keep all")
+	logSyslogFlag      = flag.Bool("log-syslog", false, "send the access log and server messages to syslog instead of stdout and stderr")
+	syslogAddrFlag     = flag.String("syslog-addr", "", "syslog daemon to send to with -log-syslog, e.g.: udp://logs:514, the local daemon when empty")
+	syslogFacilityFlag = flag.String("syslog-facility", "local0", "syslog facility for -log-syslog: kern, user, daemon or local0 to local7")
+
+	delayFlag       = flag.Duration("delay", 0, "time to wait before every response, e.g.: 250ms")
+	delayJitterFlag = flag.Duration("delay-jitter", 0, "maximum random time added to -delay for each response")
+	maxDelayFlag    = flag.Duration("max-delay", 0, "longest delay a request can ask for with the X-Echo-Delay header, ?delay= or /delay/{n}, 0 to ignore the header and parameter and cap /delay/{n} at 10s")
+
+	wsMaxMessageSizeFlag = flag.Int64("ws-max-message-size", 1<<20, "largest message in bytes /ws echoes before closing the connection, 0 for no limit")
+	wsPingIntervalFlag   = flag.Duration("ws-ping-interval", 30*time.Second, "time between pings on /ws connections, closing them when unanswered for two intervals, 0 to disable")
+
+	errorRateFlag   = flag.Float64("error-rate", 0, "fraction of requests between 0 and 1 to fail with -error-status")
+	errorStatusFlag = flag.Int("error-status", 500, "status code between 400 and 599 to fail requests with at -error-rate")
+	resetRateFlag   = flag.Float64("reset-rate", 0, "fraction of requests between 0 and 1 to abort by resetting the connection")
+
+	jwtJWKSURLFlag    = flag.String("jwt-jwks-url", "", "URL of the JWKS to verify \"Authorization: Bearer\" tokens against, required on every route except the health check when set")
+	jwtIssuerFlag     = flag.String("jwt-issuer", "", "issuer bearer tokens must be issued by with -jwt-jwks-url")
+	jwtAudienceFlag   = flag.String("jwt-audience", "", "comma separated audiences, one of which bearer tokens must be issued for with -jwt-jwks-url")
+	jwtEchoClaimsFlag = flag.Bool("jwt-echo-claims", false, "send the claims of verified bearer tokens back in the X-Jwt-Claims header")
+
+	apiKeyHeaderFlag = flag.String("api-key-header", "X-Api-Key", "request header carrying the -api-key")
+
+	verifyHMACSecretFlag = flag.String("verify-hmac-secret", "", "secret requests must be signed with, as the HMAC-SHA256 of the body in -verify-hmac-header")
+	verifyHMACHeaderFlag = flag.String("verify-hmac-header", "X-Signature", "request header carrying the hex or base64 signature checked with -verify-hmac-secret")
+	signResponsesFlag    = flag.String("sign-responses", "", "secret to sign response bodies with, as the HMAC-SHA256 in -sign-responses-header")
+	signHeaderFlag       = flag.String("sign-responses-header", "X-Signature", "response header carrying the signature made with -sign-responses")
+
+	corsAllowOriginFlag  = flag.String("cors-allow-origin", "", "comma separated origins allowed to make cross-origin requests, * for any, CORS is disabled when empty")
+	corsAllowMethodsFlag = flag.String("cors-allow-methods", "GET,HEAD,POST,PUT,PATCH,DELETE", "comma separated methods allowed in cross-origin requests")
+	corsAllowHeadersFlag = flag.String("cors-allow-headers", "", "comma separated request headers allowed in cross-origin requests, those asked for when empty")
+
+	securityHeadersFlag = flag.Bool("security-headers", false, "add HSTS, X-Content-Type-Options, X-Frame-Options, Referrer-Policy and -csp headers to responses")
+	cspFlag             = flag.String("csp", "default-src 'none'; frame-ancestors 'none'", "Content-Security-Policy sent with -security-headers, empty to leave it out")
+
+	chrootFlag          = flag.String("chroot", "", "directory to confine the process to once the listeners are bound, before switching to -user, e.g.: /var/empty")
+	userFlag            = flag.String("user", "", "user, by name or ID, to switch to once the listeners are bound, e.g. to bind :80 as root but serve unprivileged")
+	groupFlag           = flag.String("group", "", "group, by name or ID, to switch to once the listeners are bound, the primary group of -user when empty")
+	seccompFlag         = flag.Bool("seccomp", false, "restrict the process to the system calls needed for serving once it is initialized, Linux on amd64 and arm64 only")
+	pidFileFlag         = flag.String("pid-file", "", "file to write the process ID to once listening, removed on shutdown")
+	shutdownTimeoutFlag = flag.Duration("shutdown-timeout", 5*time.Second, "time to wait for open requests to finish when shutting down")
+	drainDelayFlag      = flag.Duration("drain-delay", 0, "time to keep serving with /readyz failing after a shutdown is requested, before closing the listeners")
+	startupDelayFlag    = flag.Duration("startup-delay", 0, "time to wait before binding the listeners, to simulate a slow boot")
+	maxRequestsFlag     = flag.Int64("max-requests", 0, "number of requests after which the server shuts down cleanly, 0 for no limit")
+	maxLifetimeFlag     = flag.Duration("max-lifetime", 0, "time after startup at which the server shuts down cleanly, 0 for no limit")
+
+	healthPathFlag      = flag.String("health-path", "/health", "path of the health check, which skips logging, limits and authentication")
+	healthBodyFlag      = flag.String("health-body", `{"status":"ok"}`, "body of the health check response")
+	failHealthAfterFlag = flag.Duration("fail-health-after", 0, "time after startup from which /health and /livez fail, 0 for never")
+	readyAfterFlag      = flag.Duration("ready-after", 0, "time after startup during which /readyz fails, to simulate a slow warmup")
+
+	allowStatusOverrideFlag = flag.Bool("allow-status-override", false, "let requests choose the response status code with the X-Echo-Status header or ?status=")
 
 	// stdoutW and stderrW are for overriding in test.
 	stdoutW = os.Stdout
 	stderrW = os.Stderr
 )
 
+// defaultListen is used when no -listen flag is given.
+const defaultListen = ":5678"
+
+func init() {
+	flag.Var(&pathFlag, "path", "route serving its own text and status code, e.g.: \"/foo=hello:201\". May be repeated")
+	flag.Var(&headerFlag, "header", "extra \"Name: value\" header to add to every response. May be repeated")
+	flag.Var(&basicAuthFlag, "basic-auth", "\"user:pass\" credentials required to access every route except the health check. May be repeated")
+	flag.Var(&apiKeyFlag, "api-key", "key required in the -api-key-header of requests to every route except the health check. May be repeated")
+	flag.Var(&allowCIDRFlag, "allow-cidr", "comma separated CIDRs of clients allowed to access every route except the health check, all when unset. May be repeated")
+	flag.Var(&denyCIDRFlag, "deny-cidr", "comma separated CIDRs of clients denied access to every route except the health check. May be repeated")
+	flag.Var(&readyCheckFlag, "ready-check", "tcp://host:port or http(s):// URL that must be reachable for /readyz to pass. May be repeated")
+	flag.Var(&listenFlag, "listen", "address and port to listen, or unix:///path/to/socket, followed by optional "+
+		"comma separated settings: tls, h2c, http3, proxy-protocol, reuseport, freebind, transparent, bind-device=<name>. May be repeated (default \""+defaultListen+"\")")
+}
+
 func main() {
 	flag.Parse()
 
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	if *configFlag != "" {
+		if err := loadConfig(*configFlag, explicit); err != nil {
+			fmt.Fprintf(stderrW, "Invalid -config: %s\n", err)
+			os.Exit(127)
+		}
+	}
+
 	// Asking for the version?
 	if *versionFlag {
 		fmt.Fprintln(stdoutW, version.HumanVersion)
 		os.Exit(0)
 	}
 
-	// Get text to echo from env var or flag
-	echoText := os.Getenv("ECHO_TEXT")
-	if *textFlag != "" {
-		echoText = *textFlag
+	var sysLog syslogConn
+	if *logSyslogFlag {
+		if *logFileFlag != "" {
+			fmt.Fprintln(stderrW, "-log-syslog and -log-file cannot be used together!")
+			os.Exit(127)
+		}
+		var err error
+		sysLog, err = dialSyslog(*syslogAddrFlag, *syslogFacilityFlag)
+		if err != nil {
+			fmt.Fprintf(stderrW, "Invalid -log-syslog: %s\n", err)
+			os.Exit(127)
+		}
 	}
-
-	// Validation
-	if echoText == "" {
-		fmt.Fprintln(stderrW, "Missing -text option or ECHO_TEXT env var!")
+	if err := setupLogging(*logLevelFlag, sysLog); err != nil {
+		fmt.Fprintf(stderrW, "Invalid -log-level: %s\n", err)
 		os.Exit(127)
 	}
 
@@ -55,42 +251,654 @@ func main() {
 		os.Exit(127)
 	}
 
-	// Flag gets printed as a page
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", httpLog(stdoutW, withAppHeaders(*statusFlag, httpEcho(echoText))))
+	if handled, err := serviceCommand(); handled {
+		if err != nil {
+			fmt.Fprintf(stderrW, "Failed to manage the service: %s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
-	// Health endpoint
-	mux.HandleFunc("/health", withAppHeaders(200, httpHealth()))
+	tlsConf, certs, err := tlsConfig()
+	if err != nil {
+		fmt.Fprintf(stderrW, "Invalid TLS configuration: %s\n", err)
+		os.Exit(127)
+	}
+	if certs != nil && *tlsReloadIntervalFlag > 0 {
+		go certs.Watch(*tlsReloadIntervalFlag)
+	}
+	var socketMode uint64
+	if *socketModeFlag != "" {
+		socketMode, err = strconv.ParseUint(*socketModeFlag, 8, 32)
+		if err != nil {
+			fmt.Fprintf(stderrW, "Invalid -socket-mode: %s\n", err)
+			os.Exit(127)
+		}
+	}
+
+	if len(listenFlag) == 0 {
+		listenFlag = stringSliceFlag{defaultListen}
+	}
+	specs, err := parseListenSpecs(listenFlag, tlsConf != nil, fs.FileMode(socketMode))
+	if err != nil {
+		fmt.Fprintf(stderrW, "Invalid -listen: %s\n", err)
+		os.Exit(127)
+	}
+	if tlsConf != nil && !http2Compatible(tlsConf) {
+		log.Printf("[WARN] -tls-ciphers excludes the suites required by HTTP/2, serving HTTP/1.1 only")
+	}
+
+	var logOut io.Writer = stdoutW
+	switch {
+	case sysLog != nil:
+		logOut = syslogAccessWriter{conn: sysLog}
+	case *logFileFlag != "":
+		logOut = &lumberjack.Logger{
+			Filename:   *logFileFlag,
+			MaxSize:    *logMaxSizeFlag,
+			MaxBackups: *logMaxBackupsFlag,
+		}
+	}
+
+	var grpcServer, sharedGRPC *grpc.Server
+	if *grpcFlag || *grpcListenFlag != "" {
+		checks, err := parseReadyChecks(readyCheckFlag)
+		if err != nil {
+			fmt.Fprintf(stderrW, "Invalid configuration: -ready-check: %s\n", err)
+			os.Exit(127)
+		}
+		grpcServer = newGRPCServer(tlsConf, checks)
+	}
+	if *grpcFlag {
+		sharedGRPC = grpcServer
+	}
+
+	handler, err := newLiveHandler(explicit, logOut, sharedGRPC)
+	if err != nil {
+		fmt.Fprintf(stderrW, "Invalid configuration: %s\n", err)
+		os.Exit(127)
+	}
+
+	var h http.Handler = handler
+	if *enableMetricsFlag {
+		h = withMetrics(h)
+	}
+	if *statsdAddrFlag != "" {
+		statsd, err := newStatsdClient(*statsdAddrFlag, *statsdPrefixFlag)
+		if err != nil {
+			fmt.Fprintf(stderrW, "Invalid -statsd-addr: %s\n", err)
+			os.Exit(127)
+		}
+		h = withStatsd(statsd, h)
+	}
+	var tracer *sdktrace.TracerProvider
+	if *otlpEndpointFlag != "" {
+		tracer, err = newTracerProvider(*otlpEndpointFlag)
+		if err != nil {
+			fmt.Fprintf(stderrW, "Invalid -otlp-endpoint: %s\n", err)
+			os.Exit(127)
+		}
+		h = withTracing(h)
+	}
+	// limitCh receives the reason for shutting down once -max-requests or
+	// -max-lifetime is reached.
+	limitCh := make(chan string, 1)
+	limitReached := func(reason string) {
+		select {
+		case limitCh <- reason:
+		default:
+		}
+	}
+	if *maxRequestsFlag > 0 {
+		probes := []string{*healthPathFlag, "/livez", "/readyz", "/metrics"}
+		h = withRequestLimit(*maxRequestsFlag, probes, func() { limitReached("served -max-requests requests") }, h)
+	}
+	if *maxLifetimeFlag > 0 {
+		time.AfterFunc(*maxLifetimeFlag, func() { limitReached("reached -max-lifetime") })
+	}
 
-	server := &http.Server{
-		Addr:    *listenFlag,
-		Handler: mux,
+	if *startupDelayFlag > 0 {
+		log.Printf("[INFO] waiting %s before listening", *startupDelayFlag)
+		time.Sleep(*startupDelayFlag)
 	}
-	serverCh := make(chan struct{})
-	go func() {
-		log.Printf("[INFO] server is listening on %s\n", *listenFlag)
-		if err := server.ListenAndServe(); err != http.ErrServerClosed {
-			log.Fatalf("[ERR] server exited with: %s", err)
+	health.WarmUp(*readyAfterFlag)
+	health.FailAfter(*failHealthAfterFlag)
+	listeners := newListenerManager(h, tlsConf)
+	for _, spec := range specs {
+		if err := listeners.Listen(spec); err != nil {
+			log.Fatalf("[ERR] failed to listen on %s: %s", spec.Addr, err)
 		}
-		close(serverCh)
-	}()
+	}
 
 	signalCh := make(chan os.Signal, 1)
-	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(signalCh, append([]os.Signal{os.Interrupt, syscall.SIGTERM}, controlSignals...)...)
+	requestShutdown := func() {
+		select {
+		case signalCh <- syscall.SIGTERM:
+		default:
+		}
+	}
+	runService(requestShutdown)
+
+	// Admin API
+	var admin *http.Server
+	var adminLn net.Listener
+	if *adminListenFlag != "" {
+		noProxies, _ := newClientIPResolver(nil)
+		adminLog, err := newAccessLogger(logOut, *logFormatFlag, *logTemplateFlag, noProxies)
+		if err != nil {
+			fmt.Fprintf(stderrW, "Invalid access log configuration: %s\n", err)
+			os.Exit(127)
+		}
+		admin = &http.Server{
+			Addr:    *adminListenFlag,
+			Handler: adminHandler(handler, adminLog, requestShutdown),
+		}
+		adminLn, err = createListener(*adminListenFlag, listenerOpts{})
+		if err != nil {
+			log.Fatalf("[ERR] failed to listen on %s: %s", *adminListenFlag, err)
+		}
+		log.Printf("[INFO] admin API is listening on %s", *adminListenFlag)
+	}
+
+	// gRPC listener
+	var grpcLn net.Listener
+	if *grpcListenFlag != "" {
+		grpcLn, err = createListener(*grpcListenFlag, listenerOpts{})
+		if err != nil {
+			log.Fatalf("[ERR] failed to listen on %s: %s", *grpcListenFlag, err)
+		}
+		go func() {
+			if err := grpcServer.Serve(grpcLn); err != nil {
+				log.Fatalf("[ERR] gRPC server exited with: %s", err)
+			}
+		}()
+		log.Printf("[INFO] gRPC server is listening on %s", grpcLn.Addr())
+	}
+
+	// Raw TCP echo listener
+	var tcpLn net.Listener
+	if *tcpListenFlag != "" {
+		tcpLn, err = createListener(*tcpListenFlag, globalListenerOpts(0))
+		if err != nil {
+			log.Fatalf("[ERR] failed to listen on %s: %s", *tcpListenFlag, err)
+		}
+		go serveTCPEcho(tcpLn, *tcpIdleTimeoutFlag)
+		log.Printf("[INFO] TCP echo server is listening on %s", tcpLn.Addr())
+	}
+
+	// UDP echo socket
+	var udpConn *net.UDPConn
+	if *udpListenFlag != "" {
+		udpConn, err = listenUDP(*udpListenFlag, globalListenerOpts(0))
+		if err != nil {
+			log.Fatalf("[ERR] failed to listen on %s: %s", *udpListenFlag, err)
+		}
+		go serveUDPEcho(udpConn, []byte(*udpPrefixFlag), *transparentFlag)
+		log.Printf("[INFO] UDP echo server is listening on %s", udpConn.LocalAddr())
+	}
+
+	if *pidFileFlag != "" {
+		if err := writePIDFile(*pidFileFlag); err != nil {
+			log.Fatalf("[ERR] failed to write -pid-file: %s", err)
+		}
+	}
+	if err := dropPrivileges(*chrootFlag, *userFlag, *groupFlag); err != nil {
+		log.Fatalf("[ERR] failed to drop privileges: %s", err)
+	}
+	if *chrootFlag != "" {
+		log.Printf("[INFO] confined to %s", *chrootFlag)
+	}
+	if *userFlag != "" || *groupFlag != "" {
+		log.Printf("[INFO] running as uid %d, gid %d", os.Getuid(), os.Getgid())
+	}
+	if *seccompFlag {
+		n, err := installSeccomp()
+		if err != nil {
+			log.Fatalf("[ERR] failed to apply seccomp filter: %s", err)
+		}
+		log.Printf("[INFO] restricted to %d system calls by seccomp", n)
+	}
+	// Reloads through SIGHUP or the admin API rewrite the flags, so the
+	// settings used below are read once before they are enabled.
+	pidFile := *pidFileFlag
+	seccomp := *seccompFlag
+	tlsCert := *tlsCertFlag
+	drainDelay := *drainDelayFlag
+	shutdownTimeout := *shutdownTimeoutFlag
+	if admin != nil {
+		go func() {
+			if err := admin.Serve(adminLn); err != http.ErrServerClosed {
+				log.Fatalf("[ERR] admin server exited with: %s", err)
+			}
+		}()
+	}
+
+	notifyUpgraded()
+	sdNotify("READY=1\nMAINPID=" + strconv.Itoa(os.Getpid()))
+	go sdWatchdog(health.Live)
 
-	// Wait for interrupt
-	<-signalCh
+	// Wait for interrupt, reloading the configuration and certificates on
+	// SIGHUP, or for SIGUSR2 to hand the listeners over to a new process.
+	// Listener and TLS settings only take effect on restart.
+	exitCode := 2
+	upgraded := false
+wait:
+	for {
+		select {
+		case reason := <-limitCh:
+			log.Printf("[INFO] %s, shutting down...", reason)
+			exitCode = 0
+			break wait
+		case sig := <-signalCh:
+			if isUpgradeSignal(sig) {
+				if seccomp {
+					log.Printf("[ERR] failed to upgrade: not possible with -seccomp")
+					continue
+				}
+				lns := listeners.Listeners()
+				if adminLn != nil {
+					lns = append(lns[:len(lns):len(lns)], adminLn)
+				}
+				if grpcLn != nil {
+					lns = append(lns[:len(lns):len(lns)], grpcLn)
+				}
+				if tcpLn != nil {
+					lns = append(lns[:len(lns):len(lns)], tcpLn)
+				}
+				pcs := listeners.PacketConns()
+				if udpConn != nil {
+					pcs = append(pcs[:len(pcs):len(pcs)], udpConn)
+				}
+				if err := upgrade(lns, pcs); err != nil {
+					log.Printf("[ERR] failed to upgrade: %s", err)
+					continue
+				}
+				log.Printf("[INFO] handed the listeners over to the new process, shutting down...")
+				exitCode = 0
+				upgraded = true
+				break wait
+			}
+			if !isReloadSignal(sig) {
+				log.Printf("[INFO] received interrupt, shutting down...")
+				break wait
+			}
+		}
+		if err := handler.Reload(); err != nil {
+			log.Printf("[ERR] failed to reload configuration: %s", err)
+		} else {
+			log.Printf("[INFO] reloaded configuration")
+		}
+		if certs == nil {
+			continue
+		}
+		if err := certs.Reload(); err != nil {
+			log.Printf("[ERR] failed to reload TLS key pair: %s", err)
+			continue
+		}
+		log.Printf("[INFO] reloaded TLS key pair from %s", tlsCert)
+	}
 
-	log.Printf("[INFO] received interrupt, shutting down...")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	health.SetReady(false)
+	if !upgraded {
+		sdNotify("STOPPING=1")
+	}
+	if pidFile != "" {
+		if err := removePIDFile(pidFile); err != nil {
+			log.Printf("[ERR] failed to remove -pid-file: %s", err)
+		}
+	}
+	if drainDelay > 0 {
+		log.Printf("[INFO] draining for %s before closing the listeners", drainDelay)
+		time.Sleep(drainDelay)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 
-	if err := server.Shutdown(ctx); err != nil {
+	if admin != nil {
+		admin.Shutdown(ctx)
+	}
+	if grpcServer != nil {
+		shutdownGRPC(ctx, grpcServer)
+	}
+	if tcpLn != nil {
+		tcpLn.Close()
+	}
+	if udpConn != nil {
+		udpConn.Close()
+	}
+	if err := listeners.Shutdown(ctx); err != nil {
 		log.Fatalf("[ERR] failed to shutdown server: %s", err)
 	}
+	if tracer != nil {
+		if err := tracer.Shutdown(ctx); err != nil {
+			log.Printf("[ERR] failed to flush traces: %s", err)
+		}
+	}
+
+	stopService()
 
-	// If we got this far, it was an interrupt, so don't exit cleanly
-	os.Exit(2)
+	// Unless a limit was reached, it was an interrupt, so don't exit cleanly
+	os.Exit(exitCode)
+}
+
+// newHandler builds the request handler from the current flag values, writing
+// the access log to logOut. Any background work it starts, such as watching
+// -text-file, ends when stop is closed.
+func newHandler(stop <-chan struct{}, logOut io.Writer, grpcServer *grpc.Server, limits *rateLimits) (http.Handler, error) {
+	// Get text to echo from env var or flag
+	echoText := os.Getenv("ECHO_TEXT")
+	if *textFlag != "" {
+		echoText = *textFlag
+	}
+
+	// Validation
+	if *textFileFlag != "" && *textFlag != "" {
+		return nil, errors.New("-text and -text-file cannot be used together")
+	}
+	serveRoot := *serveDirFlag != "" && *serveDirPrefixFlag == "/"
+	if echoText == "" && *textFileFlag == "" && !*echoRequestFlag && !serveRoot && *redirectToFlag == "" && *proxyUpstreamFlag == "" {
+		return nil, errors.New("missing -text option, -text-file option, ECHO_TEXT env var, -echo-request, -redirect-to or -proxy-upstream")
+	}
+	if *proxyUpstreamFlag != "" && (*redirectToFlag != "" || *serveDirFlag != "" || len(pathFlag) > 0) {
+		return nil, errors.New("-proxy-upstream cannot be combined with -redirect-to, -serve-dir or -path")
+	}
+	if !isRedirect(*redirectStatusFlag) {
+		return nil, errors.New("-redirect-status must be a 3xx status code")
+	}
+
+	extraHeaders, err := parseHeaders(headerFlag)
+	if err != nil {
+		return nil, fmt.Errorf("-header: %w", err)
+	}
+
+	clientIPs, err := newClientIPResolver(splitList(*trustedProxiesFlag))
+	if err != nil {
+		return nil, fmt.Errorf("-trusted-proxies: %w", err)
+	}
+
+	accessLog, err := newAccessLogger(logOut, *logFormatFlag, *logTemplateFlag, clientIPs)
+	if err != nil {
+		return nil, fmt.Errorf("access log: %w", err)
+	}
+
+	if *errorRateFlag < 0 || *errorRateFlag > 1 {
+		return nil, errors.New("-error-rate must be between 0 and 1")
+	}
+	if *compressLevelFlag < -1 || *compressLevelFlag > 9 {
+		return nil, errors.New("-compress-level must be between 1 and 9, or -1 for the default")
+	}
+	if *resetRateFlag < 0 || *resetRateFlag > 1 {
+		return nil, errors.New("-reset-rate must be between 0 and 1")
+	}
+	if *statusFlag < 100 || *statusFlag > 999 {
+		return nil, errors.New("-status-code must be between 100 and 999")
+	}
+	if *errorStatusFlag < 400 || *errorStatusFlag > 599 {
+		return nil, errors.New("-error-status must be an error status code between 400 and 599")
+	}
+	if grpcServer != nil && *verifyHMACSecretFlag != "" {
+		return nil, errors.New("-verify-hmac-secret cannot be combined with -grpc")
+	}
+
+	limiter, updateLimiter := limits.Global(*rateLimitFlag, *rateLimitBurstFlag)
+	clientLimiter, updateClientLimiter := limits.Clients(*rateLimitPerIPFlag, *rateLimitPerIPBurstFlag, *rateLimitPerIPClientsFlag)
+
+	// Flag gets printed as a page, optionally followed by the request
+	buildEcho := func(text string) (http.HandlerFunc, error) {
+		if !*templateFlag {
+			return httpEcho(text), nil
+		}
+		tmpl, err := template.New("text").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+		return httpEchoTemplate(tmpl, clientIPs), nil
+	}
+
+	var echo http.HandlerFunc
+	modTime := fixedTime(time.Now())
+	switch {
+	case *textFileFlag != "":
+		f, err := newTextFile(*textFileFlag, buildEcho)
+		if err != nil {
+			return nil, fmt.Errorf("-text-file: %w", err)
+		}
+		if *textFileIntervalFlag > 0 {
+			go f.Watch(*textFileIntervalFlag, stop)
+		}
+		echo = withRanges(f.ModTime, f.ServeHTTP)
+		modTime = f.ModTime
+	case echoText != "":
+		echo, err = buildEcho(echoText)
+		if err != nil {
+			return nil, fmt.Errorf("-text: %w", err)
+		}
+	}
+	if *echoRequestFlag {
+		echo = httpEchoRequest(echo)
+	}
+	echo = withNegotiation(*negotiateFlag, echo)
+	echo = withResponseSize(*responseSizeFlag, echo)
+	echo = withConditional(*conditionalFlag && *statusFlag == http.StatusOK, modTime, echo)
+	if *redirectToFlag != "" {
+		echo = httpRedirect(*redirectToFlag, *redirectStatusFlag)
+	}
+	var proxy http.HandlerFunc
+	if *proxyUpstreamFlag != "" {
+		upstream, err := parseUpstream(*proxyUpstreamFlag)
+		if err != nil {
+			return nil, fmt.Errorf("-proxy-upstream: %w", err)
+		}
+		proxy = httpProxy(upstream)
+	}
+	var shadow *mirror
+	if *mirrorToFlag != "" {
+		u, err := parseUpstream(*mirrorToFlag)
+		if err != nil {
+			return nil, fmt.Errorf("-mirror-to: %w", err)
+		}
+		shadow = newMirror(u)
+	}
+	maxAge, err := cacheMaxAge(*cacheControlFlag)
+	if err != nil {
+		return nil, fmt.Errorf("-cache-control: %w", err)
+	}
+	creds, err := parseBasicAuth(basicAuthFlag)
+	if err != nil {
+		return nil, fmt.Errorf("-basic-auth: %w", err)
+	}
+	allowCIDRs, err := parsePrefixes(allowCIDRFlag)
+	if err != nil {
+		return nil, fmt.Errorf("-allow-cidr: %w", err)
+	}
+	denyCIDRs, err := parsePrefixes(denyCIDRFlag)
+	if err != nil {
+		return nil, fmt.Errorf("-deny-cidr: %w", err)
+	}
+	jwtAuth, err := newJWTVerifier(*jwtJWKSURLFlag, *jwtIssuerFlag, *jwtAudienceFlag)
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil && jwtAuth != nil {
+		return nil, errors.New("-basic-auth cannot be combined with -jwt-jwks-url")
+	}
+	cors := newCORSPolicy(*corsAllowOriginFlag, *corsAllowMethodsFlag, *corsAllowHeadersFlag)
+	allowlist, err := parseMethods(*methodsFlag)
+	if err != nil {
+		return nil, fmt.Errorf("-methods: %w", err)
+	}
+	methods, err := parseMethods(*allowedMethodsFlag)
+	if err != nil {
+		return nil, fmt.Errorf("-allowed-methods: %w", err)
+	}
+	switch {
+	case methods != nil:
+	case allowlist != nil:
+		methods = allowlist
+	default:
+		methods = defaultMethods
+	}
+	// Proxied requests keep their delay parameter for the upstream.
+	maxDelay := *maxDelayFlag
+	if proxy != nil {
+		maxDelay = 0
+	}
+	// wrap wraps an endpoint in the logging, limiting and header middleware
+	// shared by everything except the health check, innermost first.
+	// Streaming endpoints and proxied responses are left unsigned, as
+	// signing buffers the body.
+	wrap := func(status int, streaming bool, h http.HandlerFunc) http.HandlerFunc {
+		h = withCacheControl(*cacheControlFlag, maxAge, h)
+		h = withSecurityHeaders(*securityHeadersFlag, *cspFlag, h)
+		h = withAppHeaders(status, extraHeaders, h)
+		h = withStatusOverride(*allowStatusOverrideFlag, h)
+		h = withMethodAllowlist(allowlist, h)
+		h = withMirror(shadow, h)
+		h = withBasicAuth(creds, h)
+		h = withJWT(jwtAuth, *jwtEchoClaimsFlag, h)
+		h = withAPIKey(*apiKeyHeaderFlag, apiKeyFlag, h)
+		if !streaming {
+			h = withResponseSigning(*signResponsesFlag, *signHeaderFlag, h)
+		}
+		h = withHMACVerify(*verifyHMACSecretFlag, *verifyHMACHeaderFlag, h)
+		h = withCORS(cors, h)
+		h = withIPFilter(allowCIDRs, denyCIDRs, clientIPs, h)
+		h = withMaxBody(*maxBodyBytesFlag, h)
+		h = withErrors(*errorRateFlag, *errorStatusFlag, h)
+		h = withResets(*resetRateFlag, h)
+		h = withDelay(*delayFlag, *delayJitterFlag, maxDelay, h)
+		h = withCompression(*compressFlag, *compressMinSizeFlag, *compressLevelFlag, h)
+		h = withClientRateLimit(clientLimiter, clientIPs, h)
+		h = withRateLimit(limiter, h)
+		h = withDebugLog(h)
+		return httpLog(accessLog, h)
+	}
+	route := func(status int, h http.HandlerFunc) http.HandlerFunc {
+		return wrap(status, false, h)
+	}
+	stream := func(h http.HandlerFunc) http.HandlerFunc {
+		return wrap(200, true, h)
+	}
+
+	mux := http.NewServeMux()
+	switch {
+	case proxy != nil:
+		// The upstream decides which methods it accepts and what it
+		// responds with.
+		mux.HandleFunc("/", wrap(*statusFlag, true, proxy))
+	case !serveRoot:
+		mux.HandleFunc("/", route(*statusFlag, withMethods(methods, withContentType(*contentTypeFlag, echo))))
+	}
+
+	// Static files
+	if *serveDirFlag != "" {
+		prefix := "/" + strings.Trim(*serveDirPrefixFlag, "/")
+		files := http.FileServer(http.Dir(*serveDirFlag))
+		if prefix != "/" {
+			prefix += "/"
+			files = http.StripPrefix(strings.TrimSuffix(prefix, "/"), files)
+		}
+		mux.HandleFunc(prefix, route(200, files.ServeHTTP))
+	}
+
+	// The built-in endpoints would shadow the paths of the upstream, so
+	// they are only served by http-echo itself.
+	if proxy == nil {
+		// Request reflection
+		mux.HandleFunc("/anything", route(200, httpAnything(clientIPs)))
+		mux.HandleFunc("/anything/", route(200, httpAnything(clientIPs)))
+		mux.HandleFunc("/headers", route(200, httpHeaders()))
+		mux.HandleFunc("/ip", route(200, httpIP(clientIPs)))
+
+		// Compression
+		mux.HandleFunc("/gzip", route(200, httpCompressed("gzip", clientIPs)))
+		mux.HandleFunc("/deflate", route(200, httpCompressed("deflate", clientIPs)))
+		mux.HandleFunc("/brotli", route(200, httpCompressed("br", clientIPs)))
+
+		// Cookies
+		mux.HandleFunc("/cookies", route(200, httpCookies()))
+		mux.HandleFunc("/cookies/set", route(200, httpSetCookies()))
+		mux.HandleFunc("/cookies/delete", route(200, httpDeleteCookies()))
+
+		// Unique values
+		mux.HandleFunc("/uuid", route(200, withNoStore(httpUUID())))
+		mux.HandleFunc("/random", route(200, withNoStore(httpRandom())))
+
+		mux.HandleFunc("/status/{codes}", route(200, httpStatus()))
+		mux.HandleFunc("/delay/{n}", route(200, httpDelay(*maxDelayFlag, clientIPs)))
+
+		// Redirects
+		mux.HandleFunc("/redirect-to", route(200, httpRedirectTo()))
+		mux.HandleFunc("/redirect/{n}", route(200, httpRedirectChain()))
+
+		// Streaming
+		mux.HandleFunc("/drip", stream(httpDrip(*maxDelayFlag)))
+		mux.HandleFunc("/stream/{n}", stream(httpStream(clientIPs)))
+		mux.HandleFunc("/bytes/{n}", route(200, httpBytes()))
+		mux.HandleFunc("/sse", stream(httpSSE()))
+		mux.HandleFunc("/ws", stream(httpWebSocket(*wsMaxMessageSizeFlag, *wsPingIntervalFlag)))
+
+		// Faults
+		mux.HandleFunc("/reset", stream(httpReset()))
+		mux.HandleFunc("/truncate", stream(httpTruncate()))
+	}
+
+	// Health endpoint
+	if err := handle(mux, *healthPathFlag, withAppHeaders(200, extraHeaders, httpHealth(*healthBodyFlag, health.Healthy))); err != nil {
+		return nil, fmt.Errorf("-health-path: %w", err)
+	}
+	mux.HandleFunc("/livez", withAppHeaders(200, extraHeaders, httpProbe(health.Live)))
+	checks, err := parseReadyChecks(readyCheckFlag)
+	if err != nil {
+		return nil, fmt.Errorf("-ready-check: %w", err)
+	}
+	mux.HandleFunc("/readyz", withAppHeaders(200, extraHeaders, httpProbe(readiness(checks))))
+
+	// Metrics endpoint
+	if *enableMetricsFlag {
+		mux.Handle("/metrics", metricsHandler())
+	}
+
+	// Per-path routes
+	for _, v := range pathFlag {
+		spec, err := parseRouteSpec(v, *statusFlag)
+		if err != nil {
+			return nil, fmt.Errorf("-path: %w", err)
+		}
+		h, err := buildEcho(spec.Text)
+		if err != nil {
+			return nil, fmt.Errorf("-path %s: %w", spec.Pattern, err)
+		}
+		h = withNegotiation(*negotiateFlag, h)
+		h = withResponseSize(*responseSizeFlag, h)
+		h = withConditional(*conditionalFlag && spec.Status == http.StatusOK, fixedTime(time.Now()), h)
+		if err := handle(mux, spec.Pattern, route(spec.Status, withMethods(methods, withContentType(*contentTypeFlag, h)))); err != nil {
+			return nil, fmt.Errorf("-path: %w", err)
+		}
+	}
+
+	// The settings are valid, so the rate limits can be changed.
+	updateLimiter()
+	updateClientLimiter()
+	if grpcServer == nil {
+		return mux, nil
+	}
+	// gRPC calls go through the same access checks as the HTTP routes,
+	// health checks excepted.
+	calls := withBasicAuth(creds, grpcServer.ServeHTTP)
+	calls = withJWT(jwtAuth, false, calls)
+	calls = withAPIKey(*apiKeyHeaderFlag, apiKeyFlag, calls)
+	calls = withIPFilter(allowCIDRs, denyCIDRs, clientIPs, calls)
+	calls = withClientRateLimit(clientLimiter, clientIPs, calls)
+	calls = withRateLimit(limiter, calls)
+	return withGRPC(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, grpcHealthPrefix) {
+			grpcServer.ServeHTTP(w, r)
+			return
+		}
+		calls(w, r)
+	}), mux), nil
 }
 
 func httpEcho(v string) http.HandlerFunc {
@@ -99,8 +907,19 @@ func httpEcho(v string) http.HandlerFunc {
 	}
 }
 
-func httpHealth() http.HandlerFunc {
+// httpEchoRequest writes the output of the text handler, if any, followed by
+// the incoming request in its wire format.
+func httpEchoRequest(text http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintln(w, `{"status":"ok"}`)
+		if text != nil {
+			text(w, r)
+			fmt.Fprintln(w)
+		}
+		dump, err := httputil.DumpRequest(r, true)
+		if err != nil {
+			fmt.Fprintf(w, "failed to read request: %s\n", err)
+			return
+		}
+		w.Write(dump)
 	}
 }
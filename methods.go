@@ -0,0 +1,100 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// defaultMethods are the methods accepted when no list is configured.
+var defaultMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+// parseMethods parses a comma separated list of request methods. GET implies
+// HEAD. OPTIONS is only accepted when listed; CORS preflight requests are
+// answered before the list is checked. An empty value yields nil.
+func parseMethods(v string) ([]string, error) {
+	names := splitList(v)
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	var methods []string
+	add := func(m string) {
+		if !slices.Contains(methods, m) {
+			methods = append(methods, m)
+		}
+	}
+	for _, m := range names {
+		m = strings.ToUpper(m)
+		if strings.IndexFunc(m, func(c rune) bool {
+			return (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '-' && c != '_'
+		}) >= 0 {
+			return nil, fmt.Errorf("invalid method %q", m)
+		}
+		add(m)
+		if m == http.MethodGet {
+			add(http.MethodHead)
+		}
+	}
+	return methods, nil
+}
+
+// withMethods restricts h to the given methods. Other methods are answered
+// with a 405, OPTIONS with an empty 204, both listing the methods in the Allow
+// header. HEAD runs h but only sends its headers, with the Content-Length of
+// the body it would have written.
+func withMethods(methods []string, h http.HandlerFunc) http.HandlerFunc {
+	allow := strings.Join(methods, ", ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case !slices.Contains(methods, r.Method):
+			methodNotAllowed(w, allow)
+		case r.Method == http.MethodOptions:
+			w.Header().Set("Allow", allow)
+			w.Header().Set("Content-Length", "0")
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodHead:
+			bw := &bufferResponseWriter{ResponseWriter: w}
+			h(bw, r)
+			if w.Header().Get("Content-Length") == "" {
+				w.Header().Set("Content-Length", strconv.Itoa(bw.buf.Len()))
+			}
+			if bw.status != 0 {
+				w.WriteHeader(bw.status)
+			}
+		default:
+			h(w, r)
+		}
+	}
+}
+
+// withMethodAllowlist answers requests whose method is not in methods with a
+// 405 listing the accepted methods in the Allow header. A nil list accepts
+// every method.
+func withMethodAllowlist(methods []string, h http.HandlerFunc) http.HandlerFunc {
+	if methods == nil {
+		return h
+	}
+	allow := strings.Join(methods, ", ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !slices.Contains(methods, r.Method) {
+			methodNotAllowed(w, allow)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// methodNotAllowed sends a 405 with the given Allow header.
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,54 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsRegistry holds the metrics exposed on /metrics with -enable-metrics.
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	requestsTotal = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "http_echo_requests_total",
+		Help: "Total number of HTTP requests by status code and method.",
+	}, []string{"code", "method"})
+
+	requestsInFlight = promauto.With(metricsRegistry).NewGauge(prometheus.GaugeOpts{
+		Name: "http_echo_requests_in_flight",
+		Help: "Number of HTTP requests currently being served.",
+	})
+
+	requestDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_echo_request_duration_seconds",
+		Help:    "Time taken to serve HTTP requests by status code and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"code", "method"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// withMetrics records the request count, in-flight requests and latency of
+// every request served by h.
+func withMetrics(h http.Handler) http.Handler {
+	return promhttp.InstrumentHandlerInFlight(requestsInFlight,
+		promhttp.InstrumentHandlerDuration(requestDuration,
+			promhttp.InstrumentHandlerCounter(requestsTotal, h)))
+}
+
+// metricsHandler serves the collected metrics in the Prometheus format.
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,129 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// mirrorTimeout bounds how long a mirrored request may take.
+	mirrorTimeout = 10 * time.Second
+
+	// maxMirrorRequests caps the number of mirrored requests in flight. A
+	// slow mirror gets requests dropped beyond it rather than piling them
+	// up in memory.
+	maxMirrorRequests = 100
+)
+
+// mirror sends copies of requests to a shadow upstream, whose responses are
+// discarded.
+type mirror struct {
+	url    *url.URL
+	client *http.Client
+	sem    chan struct{}
+}
+
+// newMirror returns a mirror sending copies to the given upstream, with the
+// path and query of each request appended to those of its URL.
+func newMirror(u *url.URL) *mirror {
+	return &mirror{
+		url: u,
+		client: &http.Client{
+			Timeout: mirrorTimeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		sem: make(chan struct{}, maxMirrorRequests),
+	}
+}
+
+// withMirror tees a copy of every request served by h to the mirror, without
+// waiting for it to answer. It must sit inside the authentication and IP
+// filter middleware, so rejected requests and their credentials are not
+// passed on. Connection upgrades such as /ws are not mirrored.
+func withMirror(m *mirror, h http.HandlerFunc) http.HandlerFunc {
+	if m == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "" {
+			h(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		select {
+		case m.sem <- struct{}{}:
+			// The copy is made up front, as h may change r.
+			req, err := m.newRequest(r, body)
+			if err != nil {
+				<-m.sem
+				log.Printf("[ERR] failed to mirror %s %s: %s", r.Method, r.URL.Path, err)
+				break
+			}
+			go func() {
+				defer func() { <-m.sem }()
+				m.send(req)
+			}()
+		default:
+			log.Printf("[WARN] dropped mirrored %s %s, %d requests are still in flight", r.Method, r.URL.Path, maxMirrorRequests)
+		}
+
+		h(w, r)
+	}
+}
+
+// newRequest returns a copy of r with the given body, addressed to the
+// mirror. The path is appended as is, without cleaning, so the mirror sees
+// the same path as http-echo.
+func (m *mirror) newRequest(r *http.Request, body []byte) (*http.Request, error) {
+	u := *m.url
+	u.RawPath = strings.TrimSuffix(m.url.EscapedPath(), "/") + r.URL.EscapedPath()
+	path, err := url.PathUnescape(u.RawPath)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path
+	switch {
+	case u.RawQuery == "":
+		u.RawQuery = r.URL.RawQuery
+	case r.URL.RawQuery != "":
+		u.RawQuery += "&" + r.URL.RawQuery
+	}
+
+	req, err := http.NewRequest(r.Method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = r.Header.Clone()
+	req.Header.Set("X-Forwarded-Host", r.Host)
+	return req, nil
+}
+
+// send makes the mirrored request and discards the response.
+func (m *mirror) send(req *http.Request) {
+	start := time.Now()
+	resp, err := m.client.Do(req)
+	if err != nil {
+		log.Printf("[ERR] failed to mirror %s %s: %s", req.Method, req.URL.Path, err)
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	log.Printf("[DEBUG] mirrored %s %s: %d in %s", req.Method, req.URL, resp.StatusCode, time.Since(start))
+}
@@ -0,0 +1,172 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// negotiatedTypes are the media types the echo text can be rendered as, most
+// preferred first.
+var negotiatedTypes = []string{"text/plain", "application/json", "application/xml", "text/html"}
+
+// xmlResponse is the document sent for application/xml.
+type xmlResponse struct {
+	XMLName xml.Name `xml:"response"`
+	Text    string   `xml:"text"`
+}
+
+// withNegotiation renders the text written by h as plain text, JSON, XML or
+// HTML, depending on the Accept header of the request. Error responses are
+// passed through as they are.
+func withNegotiation(enabled bool, h http.HandlerFunc) http.HandlerFunc {
+	if !enabled {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		bw := &bufferResponseWriter{ResponseWriter: w}
+		h(bw, r)
+		if bw.status >= 400 {
+			bw.send()
+			return
+		}
+
+		text := strings.TrimSuffix(bw.buf.String(), "\n")
+		var body []byte
+		ct := acceptedType(r)
+		switch ct {
+		case "application/json":
+			b, err := marshalJSON(map[string]string{"text": text})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			body = b
+		case "application/xml":
+			b, err := xml.MarshalIndent(xmlResponse{Text: text}, "", "  ")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			body = append([]byte(xml.Header), append(b, '\n')...)
+		case "text/html":
+			body = fmt.Appendf(nil, "<!DOCTYPE html>\n<html><body><pre>%s</pre></body></html>\n", html.EscapeString(text))
+		default:
+			body = []byte(text + "\n")
+		}
+
+		w.Header().Set("Content-Type", ct+"; charset=utf-8")
+		w.Header().Del("Content-Length")
+		bw.buf.Reset()
+		bw.buf.Write(body)
+		bw.send()
+	}
+}
+
+// acceptedType picks the negotiated media type the client prefers according
+// to its Accept header, defaulting to text/plain.
+func acceptedType(r *http.Request) string {
+	best, bestQ, bestPos := "text/plain", 0.0, len(negotiatedTypes)
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		for i, t := range negotiatedTypes {
+			if !mediaTypeMatches(mt, t) {
+				continue
+			}
+			if q > bestQ || (q == bestQ && i < bestPos) {
+				best, bestQ, bestPos = t, q, i
+			}
+		}
+	}
+	return best
+}
+
+// mediaTypeMatches reports whether the media range from an Accept header,
+// such as text/* or */*, includes the media type.
+func mediaTypeMatches(accept, mt string) bool {
+	if accept == "*/*" || accept == mt {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(accept, "/*")
+	return ok && strings.HasPrefix(mt, prefix+"/")
+}
+
+// errBufferFull is returned by writes beyond the limit of a
+// bufferResponseWriter.
+var errBufferFull = errors.New("response too large to buffer")
+
+// bufferResponseWriter collects the response body and status code instead of
+// sending them, so the response can be rewritten before it goes out. The
+// status stays zero unless the handler sets one. A non-zero limit caps the
+// body size, past which writes fail and overflow is set.
+type bufferResponseWriter struct {
+	http.ResponseWriter
+	status   int
+	buf      bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+// WriteHeader implements the http.ResponseWriter interface.
+func (w *bufferResponseWriter) WriteHeader(code int) {
+	if code < 200 && code != http.StatusSwitchingProtocols {
+		// Interim responses are not part of the body, so they are sent
+		// right away.
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+// Write implements the http.ResponseWriter interface.
+func (w *bufferResponseWriter) Write(b []byte) (int, error) {
+	if w.limit > 0 && w.buf.Len()+len(b) > w.limit {
+		w.overflow = true
+		return 0, errBufferFull
+	}
+	return w.buf.Write(b)
+}
+
+// FlushError implements the flush method used by http.ResponseController. It
+// does nothing, as the body is only sent once complete.
+func (w *bufferResponseWriter) FlushError() error {
+	return nil
+}
+
+// Unwrap returns the wrapped writer for use by http.ResponseController.
+func (w *bufferResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// send sends the collected status code, if any, and body to the underlying
+// writer.
+func (w *bufferResponseWriter) send() {
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	w.ResponseWriter.Write(w.buf.Bytes())
+}
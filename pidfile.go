@@ -0,0 +1,32 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"os"
+	"strconv"
+)
+
+// writePIDFile writes the process ID to path, replacing the file atomically
+// so readers never see it half written.
+func writePIDFile(path string) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// removePIDFile removes the file written by writePIDFile, unless another
+// process has replaced it in the meantime.
+func removePIDFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if string(b) != strconv.Itoa(os.Getpid())+"\n" {
+		return nil
+	}
+	return os.Remove(path)
+}
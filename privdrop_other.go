@@ -0,0 +1,17 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !unix
+
+package main
+
+import "errors"
+
+// dropPrivileges fails when a root, user or group is given, changing them is
+// only available on unix systems.
+func dropPrivileges(root, userName, groupName string) error {
+	if root == "" && userName == "" && groupName == "" {
+		return nil
+	}
+	return errors.New("-chroot, -user and -group are not supported on this platform")
+}
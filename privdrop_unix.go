@@ -0,0 +1,89 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// dropPrivileges confines the process to the root directory, unless it is
+// empty, then switches it to the given user and group, by name or numeric ID.
+// The group defaults to the primary group of the user. Switching does nothing
+// when the process already runs as them, e.g. after an upgrade. Users and
+// groups are looked up before changing the root, so they are taken from the
+// system's databases rather than from the new root.
+func dropPrivileges(root, userName, groupName string) error {
+	if root == "" && userName == "" && groupName == "" {
+		return nil
+	}
+
+	uid, gid := os.Getuid(), os.Getgid()
+	if userName != "" {
+		u, err := lookupUser(userName)
+		if err != nil {
+			return err
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return fmt.Errorf("unexpected uid %q", u.Uid)
+		}
+		if gid, err = strconv.Atoi(u.Gid); err != nil {
+			return fmt.Errorf("unexpected gid %q", u.Gid)
+		}
+	}
+	if groupName != "" {
+		g, err := lookupGroup(groupName)
+		if err != nil {
+			return err
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return fmt.Errorf("unexpected gid %q", g.Gid)
+		}
+	}
+
+	if root != "" {
+		if err := syscall.Chroot(root); err != nil {
+			return fmt.Errorf("failed to change root to %s: %w", root, err)
+		}
+		if err := os.Chdir("/"); err != nil {
+			return err
+		}
+	}
+
+	if uid == os.Getuid() && gid == os.Getgid() {
+		return nil
+	}
+
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return fmt.Errorf("failed to set supplementary groups: %w", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("failed to set group %d: %w", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("failed to set user %d: %w", uid, err)
+	}
+	return nil
+}
+
+// lookupUser finds a user by name or numeric ID.
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return user.LookupId(name)
+	}
+	return user.Lookup(name)
+}
+
+// lookupGroup finds a group by name or numeric ID.
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return user.LookupGroupId(name)
+	}
+	return user.LookupGroup(name)
+}
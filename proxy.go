@@ -0,0 +1,67 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+)
+
+// parseUpstream parses the http:// or https:// URL of an upstream to send
+// requests to.
+func parseUpstream(v string) (*url.URL, error) {
+	u, err := url.Parse(v)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported upstream %q, expected http:// or https://", v)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host in upstream %q", v)
+	}
+	return u, nil
+}
+
+// httpProxy forwards requests to the upstream, with the path and query of the
+// request appended to those of the upstream URL, and relays its responses.
+// Unreachable upstreams are answered with a 502.
+func httpProxy(upstream *url.URL) http.HandlerFunc {
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(upstream)
+			pr.SetXForwarded()
+		},
+		Transport: loggingTransport{http.DefaultTransport},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("[ERR] failed to proxy %s %s: %s", r.Method, r.URL, err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	return proxy.ServeHTTP
+}
+
+// loggingTransport logs every request sent upstream with the status and
+// latency of its response, and the response headers at debug level.
+type loggingTransport struct {
+	http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t loggingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.RoundTripper.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] proxied %s %s: %d in %s", r.Method, r.URL, resp.StatusCode, time.Since(start))
+	if logger.IsDebug() {
+		logger.Debug("upstream response", "method", r.Method, "url", r.URL.String(), "status", resp.StatusCode, "headers", resp.Header)
+	}
+	return resp, nil
+}
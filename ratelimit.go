@@ -0,0 +1,182 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"container/list"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// newRateLimiter returns a token bucket refilled at rps tokens per second. A
+// burst of zero or less defaults to one second worth of tokens.
+func newRateLimiter(rps float64, burst int) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(rps), limiterBurst(rps, burst))
+}
+
+// limiterBurst returns burst, or one second worth of tokens when it is zero
+// or less.
+func limiterBurst(rps float64, burst int) int {
+	if burst <= 0 {
+		return int(math.Max(1, math.Ceil(rps)))
+	}
+	return burst
+}
+
+// rateLimits keeps the rate limit buckets across handler rebuilds, so
+// reloading the configuration does not refill them.
+type rateLimits struct {
+	global  *rate.Limiter
+	clients *clientLimiters
+}
+
+// Global returns the bucket for -rate-limit, or nil when rps is zero, along
+// with a function to call once the new handler is built. A bucket kept from
+// a previous handler only gets its rate and burst updated by that function,
+// so a reload that fails leaves it alone.
+func (l *rateLimits) Global(rps float64, burst int) (*rate.Limiter, func()) {
+	if rps <= 0 {
+		return nil, func() {}
+	}
+	if l.global == nil {
+		l.global = newRateLimiter(rps, burst)
+		return l.global, func() {}
+	}
+	g := l.global
+	return g, func() {
+		g.SetLimit(rate.Limit(rps))
+		g.SetBurst(limiterBurst(rps, burst))
+	}
+}
+
+// Clients is Global for the per-client buckets of -rate-limit-per-ip.
+func (l *rateLimits) Clients(rps float64, burst, size int) (*clientLimiters, func()) {
+	if rps <= 0 {
+		return nil, func() {}
+	}
+	if l.clients == nil {
+		l.clients = newClientLimiters(rps, burst, size)
+		return l.clients, func() {}
+	}
+	c := l.clients
+	return c, func() { c.Update(rps, burst, size) }
+}
+
+// withRateLimit answers requests that exceed the limiter with a 429 and a
+// Retry-After header telling the client when a token will be available.
+func withRateLimit(l *rate.Limiter, h http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !allow(w, l) {
+			return
+		}
+		h(w, r)
+	}
+}
+
+// allow takes a token from the limiter, writing a 429 response and returning
+// false when none is available.
+func allow(w http.ResponseWriter, l *rate.Limiter) bool {
+	res := l.Reserve()
+	delay := res.Delay()
+	if delay == 0 {
+		return true
+	}
+	res.Cancel()
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	return false
+}
+
+// clientLimiters keeps a token bucket per client IP. Only the most recently
+// seen clients are tracked; the least recently used bucket is evicted once
+// the cache is full.
+type clientLimiters struct {
+	rps   float64
+	burst int
+	size  int
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[netip.Addr]*list.Element
+}
+
+// clientLimiter is an entry in the clientLimiters LRU.
+type clientLimiter struct {
+	ip      netip.Addr
+	limiter *rate.Limiter
+}
+
+// newClientLimiters returns buckets refilled at rps tokens per second for up
+// to size clients.
+func newClientLimiters(rps float64, burst, size int) *clientLimiters {
+	return &clientLimiters{
+		rps:   rps,
+		burst: burst,
+		size:  max(size, 1),
+		ll:    list.New(),
+		items: make(map[netip.Addr]*list.Element),
+	}
+}
+
+// Get returns the bucket for the given client, creating it if needed.
+func (c *clientLimiters) Get(ip netip.Addr) *rate.Limiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[ip]; ok {
+		c.ll.MoveToFront(e)
+		return e.Value.(*clientLimiter).limiter
+	}
+
+	if c.ll.Len() >= c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*clientLimiter).ip)
+	}
+
+	l := newRateLimiter(c.rps, c.burst)
+	c.items[ip] = c.ll.PushFront(&clientLimiter{ip: ip, limiter: l})
+	return l
+}
+
+// Update changes the rate, burst and size of the buckets, keeping the tokens
+// of the clients already tracked.
+func (c *clientLimiters) Update(rps float64, burst, size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rps, c.burst, c.size = rps, burst, max(size, 1)
+	for e := c.ll.Front(); e != nil; e = e.Next() {
+		l := e.Value.(*clientLimiter).limiter
+		l.SetLimit(rate.Limit(rps))
+		l.SetBurst(limiterBurst(rps, burst))
+	}
+	for c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*clientLimiter).ip)
+	}
+}
+
+// withClientRateLimit is withRateLimit with a separate bucket per client IP.
+func withClientRateLimit(c *clientLimiters, ips *clientIPResolver, h http.HandlerFunc) http.HandlerFunc {
+	if c == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !allow(w, c.Get(ips.ClientIP(r))) {
+			return
+		}
+		h(w, r)
+	}
+}
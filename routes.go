@@ -0,0 +1,69 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// routeSpec is a single -path entry mapping a ServeMux pattern to the text and
+// status code served for it.
+type routeSpec struct {
+	Pattern string
+	Text    string
+	Status  int
+}
+
+// parseRouteSpec parses a -path value of the form "pattern=text[:status]",
+// e.g. "/foo=hello:201". The status defaults to def when omitted. Only a
+// trailing colon followed by three digits is taken as the status, so texts
+// such as URLs or "port:8080" are kept whole; a text that does end in one can
+// be given an explicit status after it.
+func parseRouteSpec(v string, def int) (routeSpec, error) {
+	pattern, text, ok := strings.Cut(v, "=")
+	pattern = strings.TrimSpace(pattern)
+	if !ok || pattern == "" {
+		return routeSpec{}, fmt.Errorf("invalid path %q, expected \"/path=text[:status]\"", v)
+	}
+
+	spec := routeSpec{
+		Pattern: pattern,
+		Text:    text,
+		Status:  def,
+	}
+	if i := strings.LastIndex(text, ":"); i >= 0 && isStatusCode(text[i+1:]) {
+		spec.Text = text[:i]
+		spec.Status, _ = strconv.Atoi(text[i+1:])
+	}
+	return spec, nil
+}
+
+// isStatusCode reports whether v is a three digit status code from 100 to
+// 999.
+func isStatusCode(v string) bool {
+	if len(v) != 3 || v[0] < '1' || v[0] > '9' {
+		return false
+	}
+	for _, c := range v[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// handle registers the handler for the pattern, returning an error instead of
+// panicking when the pattern is invalid or conflicts with an existing route.
+func handle(mux *http.ServeMux, pattern string, h http.HandlerFunc) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	mux.HandleFunc(pattern, h)
+	return nil
+}
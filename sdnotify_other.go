@@ -0,0 +1,12 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !unix
+
+package main
+
+// sdNotify does nothing, systemd is only available on unix systems.
+func sdNotify(string) {}
+
+// sdWatchdog does nothing, systemd is only available on unix systems.
+func sdWatchdog(func() bool) {}
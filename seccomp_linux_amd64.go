@@ -0,0 +1,19 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// seccompArch is the audit architecture the filter accepts calls from.
+const seccompArch = unix.AUDIT_ARCH_X86_64
+
+// seccompArchSyscalls are the legacy system calls that only exist on amd64
+// and may still be used by the Go runtime or the C library.
+var seccompArchSyscalls = []uintptr{
+	unix.SYS_OPEN, unix.SYS_STAT, unix.SYS_LSTAT, unix.SYS_ACCESS,
+	unix.SYS_READLINK, unix.SYS_PIPE, unix.SYS_DUP2, unix.SYS_POLL,
+	unix.SYS_EPOLL_WAIT, unix.SYS_EPOLL_CREATE, unix.SYS_ARCH_PRCTL,
+	unix.SYS_TIME, unix.SYS_RENAME, unix.SYS_UNLINK, unix.SYS_MKDIR,
+	unix.SYS_GETDENTS,
+}
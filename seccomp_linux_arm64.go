@@ -0,0 +1,13 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// seccompArch is the audit architecture the filter accepts calls from.
+const seccompArch = unix.AUDIT_ARCH_AARCH64
+
+// seccompArchSyscalls is empty, arm64 only provides the calls shared with the
+// other architectures.
+var seccompArchSyscalls []uintptr
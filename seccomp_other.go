@@ -0,0 +1,14 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !linux || !(amd64 || arm64)
+
+package main
+
+import "errors"
+
+// installSeccomp fails, syscall filtering is only available on Linux on amd64
+// and arm64.
+func installSeccomp() (int, error) {
+	return 0, errors.New("-seccomp is not supported on this platform")
+}
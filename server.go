@@ -0,0 +1,259 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// listenSpec is a single -listen entry: an address followed by optional comma
+// separated settings for that listener, e.g. ":8443,tls,http3".
+type listenSpec struct {
+	Addr  string
+	TLS   bool
+	H2C   bool
+	HTTP3 bool
+	Opts  listenerOpts
+}
+
+// parseListenSpecs parses the -listen values. Boolean listener flags such as
+// -proxy-protocol apply to every listener. When TLS is configured and no
+// listener asks for it explicitly, every listener serves TLS.
+func parseListenSpecs(values []string, tlsConfigured bool, socketMode fs.FileMode) ([]listenSpec, error) {
+	if *overflowModeFlag != "queue" && *overflowModeFlag != "reject" {
+		return nil, fmt.Errorf("unknown -overflow-mode %q", *overflowModeFlag)
+	}
+
+	specs := make([]listenSpec, 0, len(values))
+	explicitTLS := false
+	for _, v := range values {
+		parts := strings.Split(v, ",")
+		spec := listenSpec{
+			Addr: strings.TrimSpace(parts[0]),
+			H2C:  *h2cFlag,
+			Opts: globalListenerOpts(socketMode),
+		}
+		if spec.Addr == "" {
+			return nil, fmt.Errorf("missing address in %q", v)
+		}
+
+		for _, opt := range parts[1:] {
+			opt, value, _ := strings.Cut(strings.TrimSpace(opt), "=")
+			switch opt {
+			case "tls":
+				spec.TLS = true
+				explicitTLS = true
+			case "h2c":
+				spec.H2C = true
+			case "http3":
+				spec.HTTP3 = true
+			case "proxy-protocol":
+				spec.Opts.ProxyProtocol = true
+			case "reuseport":
+				spec.Opts.ReusePort = true
+			case "freebind":
+				spec.Opts.Freebind = true
+			case "transparent":
+				spec.Opts.Transparent = true
+			case "bind-device":
+				spec.Opts.BindDevice = value
+			default:
+				return nil, fmt.Errorf("unknown option %q in %q", opt, v)
+			}
+		}
+		specs = append(specs, spec)
+	}
+
+	for i := range specs {
+		spec := &specs[i]
+		if tlsConfigured && !explicitTLS {
+			spec.TLS = true
+		}
+		unix := strings.HasPrefix(spec.Addr, unixPrefix)
+		if *http3Flag && spec.TLS && !unix {
+			spec.HTTP3 = true
+		}
+
+		switch {
+		case spec.TLS && !tlsConfigured:
+			return nil, fmt.Errorf("%s: tls requires -tls-cert, -tls-self-signed or -acme-domain", spec.Addr)
+		case spec.HTTP3 && !spec.TLS:
+			return nil, fmt.Errorf("%s: http3 requires TLS", spec.Addr)
+		case spec.HTTP3 && unix:
+			return nil, fmt.Errorf("%s: http3 cannot be used with a unix socket", spec.Addr)
+		}
+	}
+
+	return specs, nil
+}
+
+// globalListenerOpts returns the listener settings given by the listener
+// flags, which apply to every listener unless its -listen entry adds more.
+func globalListenerOpts(socketMode fs.FileMode) listenerOpts {
+	return listenerOpts{
+		MaxConns:       *maxConnsFlag,
+		OverflowReject: *overflowModeFlag == "reject",
+		ProxyProtocol:  *proxyProtocolFlag,
+		SocketMode:     socketMode,
+		ReusePort:      *reusePortFlag,
+		BindDevice:     *bindDeviceFlag,
+		Freebind:       *freebindFlag,
+		Transparent:    *transparentFlag,
+		KeepAlive: net.KeepAliveConfig{
+			Enable:   *tcpKeepAliveFlag >= 0,
+			Idle:     *tcpKeepAliveFlag,
+			Interval: *tcpKeepAliveIntervalFlag,
+			Count:    *tcpKeepAliveCountFlag,
+		},
+	}
+}
+
+// listenerManager serves the same handler on every configured listener and
+// coordinates their shutdown.
+type listenerManager struct {
+	handler http.Handler
+	tlsConf *tls.Config
+
+	listeners []net.Listener
+	servers   []*http.Server
+	h3servers []*http3.Server
+	h3conns   []net.PacketConn
+}
+
+// newListenerManager returns a manager serving h. The TLS configuration is
+// used by listeners with TLS enabled.
+func newListenerManager(h http.Handler, tlsConf *tls.Config) *listenerManager {
+	return &listenerManager{
+		handler: h,
+		tlsConf: tlsConf,
+	}
+}
+
+// Listen binds the listener described by spec and starts serving on it.
+func (m *listenerManager) Listen(spec listenSpec) error {
+	ln, err := createListener(spec.Addr, spec.Opts)
+	if err != nil {
+		return err
+	}
+
+	handler := m.handler
+	if spec.Opts.MaxConns > 0 && spec.Opts.OverflowReject {
+		handler = withOverflowReject(handler)
+	}
+	if spec.HTTP3 {
+		// The UDP socket is bound here rather than by the HTTP/3 server so
+		// it can be handed over on upgrades like the TCP listener.
+		conn, err := listenUDP(spec.Addr, listenerOpts{})
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		h3server := newHTTP3Server(spec.Addr, m.handler, m.tlsConf)
+		handler = withAltSvc(h3server, handler)
+		m.h3servers = append(m.h3servers, h3server)
+		m.h3conns = append(m.h3conns, conn)
+		go func() {
+			log.Printf("[INFO] HTTP/3 server is listening on %s/udp\n", conn.LocalAddr())
+			if err := h3server.Serve(conn); err != http.ErrServerClosed {
+				log.Fatalf("[ERR] HTTP/3 server exited with: %s", err)
+			}
+		}()
+	}
+
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(!spec.TLS || http2Compatible(m.tlsConf))
+	protocols.SetUnencryptedHTTP2(spec.H2C)
+
+	server := &http.Server{
+		Addr:              spec.Addr,
+		Handler:           handler,
+		Protocols:         protocols,
+		ReadTimeout:       *readTimeoutFlag,
+		ReadHeaderTimeout: *readHeaderTimeoutFlag,
+		WriteTimeout:      *writeTimeoutFlag,
+		IdleTimeout:       *idleTimeoutFlag,
+		MaxHeaderBytes:    *maxHeaderBytesFlag,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			if isOverflowConn(c) {
+				ctx = context.WithValue(ctx, overflowKey{}, true)
+			}
+			return ctx
+		},
+	}
+	if spec.TLS {
+		server.TLSConfig = m.tlsConf
+	}
+	m.listeners = append(m.listeners, ln)
+	m.servers = append(m.servers, server)
+
+	go func() {
+		log.Printf("[INFO] server is listening on %s\n", ln.Addr())
+		var err error
+		if spec.TLS {
+			err = server.ServeTLS(ln, "", "")
+		} else {
+			err = server.Serve(ln)
+		}
+		if err != http.ErrServerClosed {
+			log.Fatalf("[ERR] server exited with: %s", err)
+		}
+	}()
+
+	return nil
+}
+
+// Listeners returns the listeners bound so far, in order.
+func (m *listenerManager) Listeners() []net.Listener {
+	return m.listeners
+}
+
+// PacketConns returns the UDP sockets of the HTTP/3 servers bound so far, in
+// order.
+func (m *listenerManager) PacketConns() []net.PacketConn {
+	return m.h3conns
+}
+
+// Shutdown gracefully stops every server, waiting for in-flight requests
+// until the context expires.
+func (m *listenerManager) Shutdown(ctx context.Context) error {
+	var wg sync.WaitGroup
+	errCh := make(chan error, len(m.servers)+len(m.h3servers))
+	for _, s := range m.servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errCh <- s.Shutdown(ctx)
+		}()
+	}
+	for _, s := range m.h3servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errCh <- s.Shutdown(ctx)
+		}()
+	}
+	wg.Wait()
+	close(errCh)
+	for _, c := range m.h3conns {
+		c.Close()
+	}
+
+	var errs []error
+	for err := range errCh {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
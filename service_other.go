@@ -0,0 +1,17 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !windows
+
+package main
+
+// serviceCommand does nothing, services are only available on Windows.
+func serviceCommand() (bool, error) {
+	return false, nil
+}
+
+// runService does nothing, services are only available on Windows.
+func runService(func()) {}
+
+// stopService does nothing, services are only available on Windows.
+func stopService() {}
@@ -0,0 +1,171 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build windows
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+var (
+	serviceFlag     = flag.String("service", "", "manage the Windows service: install it with the remaining flags as its arguments, or uninstall it")
+	serviceNameFlag = flag.String("service-name", "http-echo", "name of the Windows service")
+)
+
+// serviceStopTimeout bounds how long the service waits for the server to shut
+// down once the service manager has asked it to stop.
+const serviceStopTimeout = 30 * time.Second
+
+var (
+	// serviceStopped is closed once the server has shut down, letting the
+	// service report that it stopped.
+	serviceStopped = make(chan struct{})
+
+	// serviceDone is closed once the service manager has been told the
+	// service stopped.
+	serviceDone = make(chan struct{})
+)
+
+// serviceCommand carries out -service, reporting whether there was one.
+func serviceCommand() (bool, error) {
+	switch *serviceFlag {
+	case "":
+		return false, nil
+	case "install":
+		return true, installService(*serviceNameFlag, serviceArgs(os.Args[1:]))
+	case "uninstall":
+		return true, uninstallService(*serviceNameFlag)
+	}
+	return true, fmt.Errorf("unknown -service %q, expected install or uninstall", *serviceFlag)
+}
+
+// serviceArgs returns the arguments without -service and its value, for the
+// service to be started with.
+func serviceArgs(args []string) []string {
+	var out []string
+	for i := 0; i < len(args); i++ {
+		name := strings.TrimLeft(args[i], "-")
+		switch {
+		case name == "service":
+			i++
+		case strings.HasPrefix(name, "service="):
+		default:
+			out = append(out, args[i])
+		}
+	}
+	return out
+}
+
+// installService registers the running executable as an automatically
+// started service with the given arguments.
+func installService(name string, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", name)
+	}
+	s, err := m.CreateService(name, exe, mgr.Config{
+		DisplayName: name,
+		Description: "http-echo test server",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	log.Printf("[INFO] installed service %s", name)
+	return nil
+}
+
+// uninstallService removes the service.
+func uninstallService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", name, err)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	log.Printf("[INFO] uninstalled service %s", name)
+	return nil
+}
+
+// runService reports to the service manager when the process was started as
+// a Windows service, calling stop when it asks the service to stop. It does
+// nothing otherwise.
+func runService(stop func()) {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		close(serviceDone)
+		return
+	}
+	go func() {
+		defer close(serviceDone)
+		if err := svc.Run(*serviceNameFlag, &echoService{stop: stop}); err != nil {
+			log.Printf("[ERR] service failed: %s", err)
+		}
+	}()
+}
+
+// stopService tells the service manager, if any, that the server has shut
+// down and waits until it has been told.
+func stopService() {
+	close(serviceStopped)
+	<-serviceDone
+}
+
+// echoService implements svc.Handler.
+type echoService struct {
+	stop func()
+}
+
+// Execute implements the svc.Handler interface.
+func (s *echoService) Execute(args []string, req <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case c := <-req:
+			switch c.Cmd {
+			case svc.Interrogate:
+				status <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32(serviceStopTimeout / time.Millisecond)}
+				s.stop()
+				select {
+				case <-serviceStopped:
+				case <-time.After(serviceStopTimeout):
+					log.Printf("[ERR] timed out waiting for the server to shut down")
+				}
+				return false, 0
+			}
+		case <-serviceStopped:
+			return false, 0
+		}
+	}
+}
@@ -0,0 +1,23 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !unix
+
+package main
+
+import "os"
+
+// controlSignals is empty, reloading and upgrading on a signal are only
+// available on unix systems. The admin API can still reload the
+// configuration.
+var controlSignals []os.Signal
+
+// isReloadSignal always returns false.
+func isReloadSignal(os.Signal) bool {
+	return false
+}
+
+// isUpgradeSignal always returns false.
+func isUpgradeSignal(os.Signal) bool {
+	return false
+}
@@ -0,0 +1,25 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// controlSignals are the signals handled on top of interrupt and SIGTERM:
+// SIGHUP reloads the configuration and SIGUSR2 upgrades the binary.
+var controlSignals = []os.Signal{syscall.SIGHUP, syscall.SIGUSR2}
+
+// isReloadSignal reports whether sig asks for a configuration reload.
+func isReloadSignal(sig os.Signal) bool {
+	return sig == syscall.SIGHUP
+}
+
+// isUpgradeSignal reports whether sig asks for a binary upgrade.
+func isUpgradeSignal(sig os.Signal) bool {
+	return sig == syscall.SIGUSR2
+}
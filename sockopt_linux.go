@@ -0,0 +1,93 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// control implements the net.ListenConfig Control callback, applying the
+// socket options to the listening socket before it is bound.
+func (o listenerOpts) control(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		setInt := func(level, opt, value int, name string) {
+			if sockErr != nil {
+				return
+			}
+			if err := unix.SetsockoptInt(int(fd), level, opt, value); err != nil {
+				sockErr = fmt.Errorf("failed to set %s: %w", name, err)
+			}
+		}
+
+		if o.ReusePort {
+			setInt(unix.SOL_SOCKET, unix.SO_REUSEPORT, 1, "SO_REUSEPORT")
+		}
+		if o.Freebind {
+			if network == "tcp6" || network == "udp6" {
+				setInt(unix.SOL_IPV6, unix.IPV6_FREEBIND, 1, "IPV6_FREEBIND")
+			} else {
+				setInt(unix.SOL_IP, unix.IP_FREEBIND, 1, "IP_FREEBIND")
+			}
+		}
+		if o.Transparent {
+			if network == "tcp6" || network == "udp6" {
+				setInt(unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1, "IPV6_TRANSPARENT")
+			} else {
+				setInt(unix.SOL_IP, unix.IP_TRANSPARENT, 1, "IP_TRANSPARENT")
+			}
+			// Datagram sockets are not connected, so the original
+			// destination has to be passed along with every datagram.
+			// IPv6 sockets also receive IPv4 traffic, which is reported
+			// by the IPv4 option.
+			switch network {
+			case "udp6":
+				setInt(unix.SOL_IPV6, unix.IPV6_RECVORIGDSTADDR, 1, "IPV6_RECVORIGDSTADDR")
+				setInt(unix.SOL_IP, unix.IP_RECVORIGDSTADDR, 1, "IP_RECVORIGDSTADDR")
+			case "udp4":
+				setInt(unix.SOL_IP, unix.IP_RECVORIGDSTADDR, 1, "IP_RECVORIGDSTADDR")
+			}
+		}
+		if o.BindDevice != "" && sockErr == nil {
+			if err := unix.BindToDevice(int(fd), o.BindDevice); err != nil {
+				sockErr = fmt.Errorf("failed to set SO_BINDTODEVICE to %s: %w", o.BindDevice, err)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// originalDstSpace is the size of the out-of-band buffer needed to receive
+// the original destination of a datagram.
+var originalDstSpace = unix.CmsgSpace(unix.SizeofSockaddrInet6)
+
+// originalDst returns the original destination of a datagram received on a
+// transparent socket from its out-of-band data, as requested by
+// IP_RECVORIGDSTADDR. The data holds a sockaddr_in or sockaddr_in6, with the
+// port in network byte order.
+func originalDst(oob []byte) (netip.AddrPort, bool) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return netip.AddrPort{}, false
+	}
+	for _, m := range msgs {
+		switch {
+		case m.Header.Level == unix.SOL_IP && m.Header.Type == unix.IP_ORIGDSTADDR && len(m.Data) >= unix.SizeofSockaddrInet4:
+			addr := netip.AddrFrom4([4]byte(m.Data[4:8]))
+			return netip.AddrPortFrom(addr, binary.BigEndian.Uint16(m.Data[2:4])), true
+		case m.Header.Level == unix.SOL_IPV6 && m.Header.Type == unix.IPV6_ORIGDSTADDR && len(m.Data) >= unix.SizeofSockaddrInet6:
+			addr := netip.AddrFrom16([16]byte(m.Data[8:24]))
+			return netip.AddrPortFrom(addr, binary.BigEndian.Uint16(m.Data[2:4])), true
+		}
+	}
+	return netip.AddrPort{}, false
+}
@@ -0,0 +1,38 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"syscall"
+)
+
+// control implements the net.ListenConfig Control callback. The socket
+// options are only implemented on Linux, so requesting any of them fails.
+func (o listenerOpts) control(network, address string, c syscall.RawConn) error {
+	if o.ReusePort {
+		return fmt.Errorf("SO_REUSEPORT is not supported on this platform")
+	}
+	if o.Freebind {
+		return fmt.Errorf("IP_FREEBIND is not supported on this platform")
+	}
+	if o.Transparent {
+		return fmt.Errorf("IP_TRANSPARENT is not supported on this platform")
+	}
+	if o.BindDevice != "" {
+		return fmt.Errorf("SO_BINDTODEVICE is not supported on this platform")
+	}
+	return nil
+}
+
+// originalDstSpace is zero, transparent sockets are not supported.
+const originalDstSpace = 0
+
+// originalDst always reports false, transparent sockets are not supported.
+func originalDst([]byte) (netip.AddrPort, bool) {
+	return netip.AddrPort{}, false
+}
@@ -0,0 +1,52 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// statsdClient sends metrics to a StatsD or DogStatsD server over UDP. Sends
+// are best effort: a lost or rejected packet never affects the request.
+type statsdClient struct {
+	conn   net.Conn
+	prefix string
+}
+
+// newStatsdClient returns a client sending metrics named with the given
+// prefix to addr.
+func newStatsdClient(addr, prefix string) (*statsdClient, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &statsdClient{conn: conn, prefix: prefix}, nil
+}
+
+// send writes a single metric line, e.g. "requests:1|c". The prefix is not
+// part of the format, so it may contain a %.
+func (c *statsdClient) send(format string, args ...any) {
+	fmt.Fprintf(c.conn, "%s.%s", c.prefix, fmt.Sprintf(format, args...))
+}
+
+// withStatsd emits a request counter and timing for every request served by
+// h, tagged with the status code and method in the DogStatsD format.
+func withStatsd(c *statsdClient, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mrw := metaResponseWriter{writer: w}
+		start := time.Now()
+		h.ServeHTTP(&mrw, r)
+
+		status := mrw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		tags := fmt.Sprintf("#code:%d,method:%s", status, r.Method)
+		c.send("requests:1|c|%s", tags)
+		c.send("request_duration:%f|ms|%s", float64(time.Since(start))/float64(time.Millisecond), tags)
+	})
+}
@@ -0,0 +1,55 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"strings"
+)
+
+// syslogConn sends messages to a syslog daemon at a given severity. It is
+// satisfied by *syslog.Writer on the platforms that support syslog.
+type syslogConn interface {
+	Debug(m string) error
+	Info(m string) error
+	Warning(m string) error
+	Err(m string) error
+}
+
+// syslogAccessWriter sends each access log line to syslog at info severity.
+type syslogAccessWriter struct {
+	conn syslogConn
+}
+
+// Write implements the io.Writer interface.
+func (w syslogAccessWriter) Write(b []byte) (int, error) {
+	return len(b), w.conn.Info(string(bytes.TrimSuffix(b, []byte("\n"))))
+}
+
+// syslogServerWriter sends server messages formatted by the leveled logger to
+// syslog, mapping their level prefix to the syslog severity.
+type syslogServerWriter struct {
+	conn syslogConn
+}
+
+// Write implements the io.Writer interface.
+func (w syslogServerWriter) Write(b []byte) (int, error) {
+	line := strings.TrimSuffix(string(b), "\n")
+	send := w.conn.Info
+	for prefix, f := range map[string]func(string) error{
+		"[TRACE]": w.conn.Debug,
+		"[DEBUG]": w.conn.Debug,
+		"[WARN]":  w.conn.Warning,
+		"[ERROR]": w.conn.Err,
+	} {
+		if strings.HasPrefix(line, prefix) {
+			send = f
+			break
+		}
+	}
+	if _, msg, ok := strings.Cut(line, "] "); ok {
+		line = strings.TrimSpace(msg)
+	}
+	return len(b), send(line)
+}
@@ -0,0 +1,13 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build windows || plan9
+
+package main
+
+import "fmt"
+
+// dialSyslog always fails, syslog is not available on this platform.
+func dialSyslog(addr, facility string) (syslogConn, error) {
+	return nil, fmt.Errorf("syslog is not supported on this platform")
+}
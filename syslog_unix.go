@@ -0,0 +1,48 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+	"strings"
+
+	"github.com/hashicorp/http-echo/version"
+)
+
+// syslogFacilities maps the accepted -syslog-facility values to facilities.
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":   syslog.LOG_KERN,
+	"user":   syslog.LOG_USER,
+	"daemon": syslog.LOG_DAEMON,
+	"local0": syslog.LOG_LOCAL0,
+	"local1": syslog.LOG_LOCAL1,
+	"local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3,
+	"local4": syslog.LOG_LOCAL4,
+	"local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6,
+	"local7": syslog.LOG_LOCAL7,
+}
+
+// dialSyslog connects to the syslog daemon at addr, given as udp://host:port
+// or tcp://host:port, or to the local daemon when addr is empty.
+func dialSyslog(addr, facility string) (syslogConn, error) {
+	f, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown facility %q", facility)
+	}
+
+	var network string
+	if addr != "" {
+		var ok bool
+		network, addr, ok = strings.Cut(addr, "://")
+		if !ok || (network != "udp" && network != "tcp") {
+			return nil, fmt.Errorf("address must be udp://host:port or tcp://host:port")
+		}
+	}
+	return syslog.Dial(network, addr, f|syslog.LOG_INFO, version.Name)
+}
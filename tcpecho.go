@@ -0,0 +1,68 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"time"
+)
+
+// serveTCPEcho accepts connections on ln and writes everything received on
+// them back to the sender, for testing L4 load balancers and TPROXY setups.
+// Connections are closed after being idle for the given timeout, unless it is
+// zero. It returns once the listener is closed.
+func serveTCPEcho(ln net.Listener, idleTimeout time.Duration) {
+	var backoff time.Duration
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// Keep going on failures such as running out of file
+			// descriptors, backing off like net/http does.
+			backoff = min(max(2*backoff, 5*time.Millisecond), time.Second)
+			log.Printf("[ERR] failed to accept TCP connection: %s, retrying in %s", err, backoff)
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+		go echoTCP(c, idleTimeout)
+	}
+}
+
+// echoTCP copies the data read from c back to it until the peer closes its
+// side of the connection, and logs the local address, which is the original
+// destination for redirected connections.
+func echoTCP(c net.Conn, idleTimeout time.Duration) {
+	defer c.Close()
+	if isOverflowConn(c) {
+		return
+	}
+
+	start := time.Now()
+	var n int
+	buf := make([]byte, 32<<10)
+	for {
+		if idleTimeout > 0 {
+			c.SetReadDeadline(time.Now().Add(idleTimeout))
+		}
+		nr, err := c.Read(buf)
+		if nr > 0 {
+			if _, werr := c.Write(buf[:nr]); werr != nil {
+				break
+			}
+			n += nr
+		}
+		if err != nil {
+			if tc, ok := tcpConnOf(c); ok {
+				tc.CloseWrite()
+			}
+			break
+		}
+	}
+	log.Printf("[INFO] TCP connection from %s to %s closed after echoing %d bytes in %s", c.RemoteAddr(), c.LocalAddr(), n, time.Since(start))
+}
@@ -0,0 +1,54 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"text/template"
+)
+
+// templateData is the context available to the -text template.
+type templateData struct {
+	Method     string
+	Path       string
+	Query      url.Values
+	Proto      string
+	Host       string
+	Header     http.Header
+	RemoteAddr string
+	ClientIP   string
+	Hostname   string
+}
+
+// httpEchoTemplate renders the template for every request. The output is
+// buffered so a failing template results in a 500 rather than a partial body.
+func httpEchoTemplate(tmpl *template.Template, ips *clientIPResolver) http.HandlerFunc {
+	hostname, _ := os.Hostname()
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := templateData{
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Query:      r.URL.Query(),
+			Proto:      r.Proto,
+			Host:       r.Host,
+			Header:     r.Header,
+			RemoteAddr: r.RemoteAddr,
+			ClientIP:   clientAddr(ips, r),
+			Hostname:   hostname,
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			log.Printf("[ERR] failed to render -text template: %s", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		buf.WriteByte('\n')
+		w.Write(buf.Bytes())
+	}
+}
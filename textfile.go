@@ -0,0 +1,123 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// textFile serves the contents of -text-file, rebuilding the echo handler
+// whenever the file is reloaded.
+type textFile struct {
+	path  string
+	build func(text string) (http.HandlerFunc, error)
+
+	mu      sync.RWMutex
+	handler http.HandlerFunc
+	modTime time.Time
+}
+
+// newTextFile reads the file and builds the initial handler from it.
+func newTextFile(path string, build func(string) (http.HandlerFunc, error)) (*textFile, error) {
+	f := &textFile{
+		path:  path,
+		build: build,
+	}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload reads the file again. The previous contents keep being served if the
+// file cannot be read or its contents are invalid.
+func (f *textFile) Reload() error {
+	modTime, err := latestModTime(f.path)
+	if err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	h, err := f.build(strings.TrimSuffix(string(b), "\n"))
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.handler = h
+	f.modTime = modTime
+	f.mu.Unlock()
+	return nil
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (f *textFile) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.RLock()
+	h := f.handler
+	f.mu.RUnlock()
+	h(w, r)
+}
+
+// ModTime returns the modification time of the file when it was last loaded.
+func (f *textFile) ModTime() time.Time {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.modTime
+}
+
+// Watch polls the file at the given interval and reloads it when it has been
+// modified, until stop is closed.
+func (f *textFile) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+
+		modTime, err := latestModTime(f.path)
+		if err != nil {
+			log.Printf("[ERR] failed to stat %s: %s", f.path, err)
+			continue
+		}
+
+		f.mu.RLock()
+		changed := !modTime.Equal(f.modTime)
+		f.mu.RUnlock()
+		if !changed {
+			continue
+		}
+
+		if err := f.Reload(); err != nil {
+			log.Printf("[ERR] failed to reload %s: %s", f.path, err)
+			continue
+		}
+		log.Printf("[INFO] reloaded text from %s", f.path)
+	}
+}
+
+// latestModTime returns the most recent modification time of the files.
+func latestModTime(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, name := range paths {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
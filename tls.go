@@ -0,0 +1,323 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsConfig builds the TLS configuration for the listener from the tls-*
+// flags. It returns nil when TLS has not been requested. When the certificate
+// is loaded from disk, the returned reloader can be used to pick up a rotated
+// key pair without restarting.
+func tlsConfig() (*tls.Config, *certReloader, error) {
+	var conf *tls.Config
+	var reloader *certReloader
+	switch {
+	case *acmeDomainFlag != "":
+		if *tlsCertFlag != "" || *tlsKeyFlag != "" || *tlsSelfSignedFlag {
+			return nil, nil, fmt.Errorf("-acme-domain cannot be combined with -tls-cert, -tls-key or -tls-self-signed")
+		}
+		conf = acmeManager().TLSConfig()
+	case *tlsSelfSignedFlag:
+		if *tlsCertFlag != "" || *tlsKeyFlag != "" {
+			return nil, nil, fmt.Errorf("-tls-self-signed cannot be combined with -tls-cert or -tls-key")
+		}
+		cert, err := selfSignedCert(splitList(*tlsSelfSignedHostsFlag))
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Printf("[INFO] generated self-signed certificate with SHA-256 fingerprint %s", fingerprint(cert.Leaf))
+		conf = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		}
+	case *tlsCertFlag == "" && *tlsKeyFlag == "":
+		return nil, nil, nil
+	case *tlsCertFlag == "" || *tlsKeyFlag == "":
+		return nil, nil, fmt.Errorf("both -tls-cert and -tls-key must be set")
+	default:
+		var err error
+		reloader, err = newCertReloader(*tlsCertFlag, *tlsKeyFlag)
+		if err != nil {
+			return nil, nil, err
+		}
+		conf = &tls.Config{
+			GetCertificate: reloader.GetCertificate,
+		}
+	}
+	if err := configureProtocol(conf); err != nil {
+		return nil, nil, err
+	}
+
+	if err := configureClientAuth(conf); err != nil {
+		return nil, nil, err
+	}
+
+	return conf, reloader, nil
+}
+
+// certReloader serves a certificate loaded from disk and swaps it out when
+// the key pair is reloaded.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// newCertReloader loads the key pair from the given files.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the key pair from disk again. The previous certificate is kept
+// if the new one cannot be loaded.
+func (r *certReloader) Reload() error {
+	modTime, err := latestModTime(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load key pair: %w", err)
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+	return nil
+}
+
+// GetCertificate implements the tls.Config GetCertificate callback.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Watch polls the key pair files at the given interval and reloads them when
+// either has been modified. It never returns.
+func (r *certReloader) Watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		modTime, err := latestModTime(r.certFile, r.keyFile)
+		if err != nil {
+			log.Printf("[ERR] failed to stat TLS key pair: %s", err)
+			continue
+		}
+
+		r.mu.RLock()
+		changed := modTime.After(r.modTime)
+		r.mu.RUnlock()
+		if !changed {
+			continue
+		}
+
+		if err := r.Reload(); err != nil {
+			log.Printf("[ERR] failed to reload TLS key pair: %s", err)
+			continue
+		}
+		log.Printf("[INFO] reloaded TLS key pair from %s", r.certFile)
+	}
+}
+
+// acmeManager returns an autocert manager for the domains given with
+// -acme-domain. Certificates are obtained with the TLS-ALPN-01 challenge, so
+// the listener must be reachable on port 443 for the configured domains.
+func acmeManager() *autocert.Manager {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(splitList(*acmeDomainFlag)...),
+		Email:      *acmeEmailFlag,
+	}
+	if *acmeCacheDirFlag != "" {
+		m.Cache = autocert.DirCache(*acmeCacheDirFlag)
+	}
+	if *acmeDirectoryFlag != "" {
+		m.Client = &acme.Client{DirectoryURL: *acmeDirectoryFlag}
+	}
+	return m
+}
+
+// selfSignedCert generates an ephemeral certificate for the given hosts. The
+// first host is used as the common name; IP addresses are added as IP SANs and
+// everything else as DNS SANs.
+func selfSignedCert(hosts []string) (tls.Certificate, error) {
+	if len(hosts) == 0 {
+		return tls.Certificate{}, fmt.Errorf("-tls-self-signed-hosts must not be empty")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// fingerprint returns the colon separated SHA-256 fingerprint of the
+// certificate, as printed by openssl.
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// tlsVersions maps the accepted -tls-min-version and -tls-max-version values
+// to their protocol versions.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// configureProtocol applies the protocol version bounds and cipher suites
+// from the -tls-min-version, -tls-max-version and -tls-ciphers flags.
+func configureProtocol(conf *tls.Config) error {
+	min, ok := tlsVersions[*tlsMinVersionFlag]
+	if !ok {
+		return fmt.Errorf("unknown -tls-min-version %q", *tlsMinVersionFlag)
+	}
+	conf.MinVersion = min
+
+	if *tlsMaxVersionFlag != "" {
+		max, ok := tlsVersions[*tlsMaxVersionFlag]
+		if !ok {
+			return fmt.Errorf("unknown -tls-max-version %q", *tlsMaxVersionFlag)
+		}
+		if max < min {
+			return fmt.Errorf("-tls-max-version must not be lower than -tls-min-version")
+		}
+		conf.MaxVersion = max
+	}
+
+	names := splitList(*tlsCiphersFlag)
+	if len(names) == 0 {
+		return nil
+	}
+
+	suites := make(map[string]uint16)
+	for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		suites[s.Name] = s.ID
+	}
+	for _, name := range names {
+		id, ok := suites[name]
+		if !ok {
+			return fmt.Errorf("unknown cipher suite %q", name)
+		}
+		conf.CipherSuites = append(conf.CipherSuites, id)
+	}
+
+	return nil
+}
+
+// http2Compatible reports whether the cipher suites in the configuration
+// satisfy the HTTP/2 requirement for an AES_128_GCM_SHA256 suite.
+func http2Compatible(conf *tls.Config) bool {
+	if len(conf.CipherSuites) == 0 {
+		return true
+	}
+	for _, id := range conf.CipherSuites {
+		if id == tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 || id == tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+			return true
+		}
+	}
+	return false
+}
+
+// configureClientAuth sets up client certificate verification on the given
+// configuration from the -tls-client-ca and -tls-require-client-cert flags.
+func configureClientAuth(conf *tls.Config) error {
+	if *tlsClientCAFlag == "" {
+		if *tlsRequireClientCertFlag {
+			return fmt.Errorf("-tls-require-client-cert requires -tls-client-ca")
+		}
+		return nil
+	}
+
+	pem, err := os.ReadFile(*tlsClientCAFlag)
+	if err != nil {
+		return fmt.Errorf("failed to read client CA: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates found in %s", *tlsClientCAFlag)
+	}
+
+	conf.ClientCAs = pool
+	conf.ClientAuth = tls.VerifyClientCertIfGiven
+	if *tlsRequireClientCertFlag {
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return nil
+}
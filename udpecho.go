@@ -0,0 +1,99 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+)
+
+// maxDatagramSize is the largest UDP payload that can be received.
+const maxDatagramSize = 64 << 10
+
+// listenUDP binds the datagram socket for the given address, applying the
+// same socket options as createListener. When the process was socket
+// activated or upgraded, the inherited socket is used instead.
+func listenUDP(addr string, opts listenerOpts) (*net.UDPConn, error) {
+	pc, err := activatedPacketConn()
+	if err != nil {
+		return nil, err
+	}
+	if pc != nil {
+		log.Printf("[INFO] using inherited socket on %s in place of %s", pc.LocalAddr(), addr)
+	} else {
+		lc := net.ListenConfig{Control: opts.control}
+		pc, err = lc.ListenPacket(context.Background(), "udp", addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c, ok := pc.(*net.UDPConn)
+	if !ok {
+		pc.Close()
+		return nil, fmt.Errorf("%s is not a UDP socket", pc.LocalAddr())
+	}
+	return c, nil
+}
+
+// serveUDPEcho sends every datagram received on c back to its sender,
+// preceded by prefix, for testing UDP load balancing and connection tracking.
+// On a transparent socket, replies are sent from the original destination of
+// the datagram so they make it back through TPROXY. It returns once the
+// connection is closed.
+func serveUDPEcho(c *net.UDPConn, prefix []byte, transparent bool) {
+	buf := make([]byte, len(prefix)+maxDatagramSize)
+	copy(buf, prefix)
+	var oob []byte
+	if transparent {
+		oob = make([]byte, originalDstSpace)
+	}
+	local := c.LocalAddr().(*net.UDPAddr).AddrPort()
+
+	for {
+		n, oobn, _, addr, err := c.ReadMsgUDPAddrPort(buf[len(prefix):], oob)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("[ERR] failed to read UDP datagram: %s", err)
+			continue
+		}
+
+		dst := local
+		if transparent {
+			if v, ok := originalDst(oob[:oobn]); ok {
+				dst = v
+			}
+		}
+		if err := echoUDP(c, local, dst, addr, buf[:len(prefix)+n]); err != nil {
+			log.Printf("[ERR] failed to echo UDP datagram to %s: %s", addr, err)
+			continue
+		}
+		log.Printf("[INFO] UDP datagram from %s to %s echoed, %d bytes", addr, dst, n)
+	}
+}
+
+// echoUDP sends b to addr from dst. Datagrams addressed to the socket itself
+// are answered through it, redirected ones through a transparent socket bound
+// to their original destination.
+func echoUDP(c *net.UDPConn, local, dst, addr netip.AddrPort, b []byte) error {
+	if dst.Port() == local.Port() && (local.Addr().IsUnspecified() || local.Addr().Unmap() == dst.Addr().Unmap()) {
+		_, err := c.WriteToUDPAddrPort(b, addr)
+		return err
+	}
+
+	lc := net.ListenConfig{Control: listenerOpts{Transparent: true}.control}
+	pc, err := lc.ListenPacket(context.Background(), "udp", dst.String())
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	_, err = pc.(*net.UDPConn).WriteToUDPAddrPort(b, addr)
+	return err
+}
@@ -0,0 +1,146 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+const (
+	// upgradeFDsEnv tells a process started on SIGUSR2 how many listening
+	// sockets it inherited, starting at listenFDsStart.
+	upgradeFDsEnv = "HTTP_ECHO_UPGRADE_FDS"
+
+	// upgradeReadyEnv names the file descriptor a process started on SIGUSR2
+	// writes to once it is serving, so its parent can shut down.
+	upgradeReadyEnv = "HTTP_ECHO_UPGRADE_READY_FD"
+
+	// upgradeTimeout bounds how long the new process may take to get ready.
+	upgradeTimeout = time.Minute
+)
+
+// upgrade starts the executable again with the same arguments, handing it
+// the given listeners and packet connections, and waits until it is serving.
+// The caller then shuts down to let the new process take over. Any error
+// leaves the current process in charge.
+func upgrade(lns []net.Listener, pcs []net.PacketConn) error {
+	path, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return err
+	}
+
+	files := make([]*os.File, 0, len(lns)+len(pcs)+1)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, ln := range lns {
+		f, err := listenerFile(ln)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+	for _, pc := range pcs {
+		f, err := packetConnFile(pc)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+	n := len(files)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	files = append(files, w)
+
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Env = append(os.Environ(),
+		upgradeFDsEnv+"="+strconv.Itoa(n),
+		upgradeReadyEnv+"="+strconv.Itoa(listenFDsStart+n),
+		// The new process becomes the main process of a systemd unit,
+		// so it takes over the watchdog.
+		"WATCHDOG_PID=",
+	)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	w.Close()
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+	case <-time.After(upgradeTimeout):
+		err = errors.New("timed out")
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("new process did not get ready: %w", err)
+	}
+	cmd.Process.Release()
+
+	// The new process serves the same unix sockets, so they must survive
+	// the listeners being closed here.
+	for _, ln := range lns {
+		if ul, ok := rawListener(ln).(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	return nil
+}
+
+// listenerFile returns a duplicate of the file descriptor underneath ln.
+func listenerFile(ln net.Listener) (*os.File, error) {
+	switch l := rawListener(ln).(type) {
+	case *net.TCPListener:
+		return l.File()
+	case *net.UnixListener:
+		return l.File()
+	}
+	return nil, fmt.Errorf("cannot hand over listener on %s", ln.Addr())
+}
+
+// packetConnFile returns a duplicate of the file descriptor underneath pc.
+func packetConnFile(pc net.PacketConn) (*os.File, error) {
+	if c, ok := pc.(*net.UDPConn); ok {
+		return c.File()
+	}
+	return nil, fmt.Errorf("cannot hand over socket on %s", pc.LocalAddr())
+}
+
+// notifyUpgraded tells the parent process that started this one on SIGUSR2,
+// if any, that it is serving.
+func notifyUpgraded() {
+	v := os.Getenv(upgradeReadyEnv)
+	if v == "" {
+		return
+	}
+	os.Unsetenv(upgradeReadyEnv)
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return
+	}
+	f := os.NewFile(uintptr(fd), "upgrade-ready")
+	f.Write([]byte{1})
+	f.Close()
+}
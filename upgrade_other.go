@@ -0,0 +1,19 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// upgrade always fails, binary upgrades are only available on unix systems.
+func upgrade([]net.Listener, []net.PacketConn) error {
+	return errors.New("binary upgrades are not supported on this platform")
+}
+
+// notifyUpgraded does nothing.
+func notifyUpgraded() {}
@@ -0,0 +1,101 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsWriteWait bounds how long writing a single frame may take.
+const wsWriteWait = 10 * time.Second
+
+// httpWebSocket upgrades the connection to a WebSocket and sends every text
+// and binary message back unchanged, streaming it so large messages are not
+// buffered. Messages above maxSize bytes close the connection with status
+// 1009, no limit when zero. When pingInterval is positive, the connection is
+// pinged at that interval and closed when no pong or message arrives for two
+// intervals. Any origin is accepted, there is nothing to protect behind the
+// endpoint.
+func httpWebSocket(maxSize int64, pingInterval time.Duration) http.HandlerFunc {
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(*http.Request) bool { return true },
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The headers set by the middleware go out with the 101 response.
+		conn, err := upgrader.Upgrade(hijackWriter{w}, r, w.Header())
+		if err != nil {
+			// The upgrader already responded with an error.
+			return
+		}
+		defer conn.Close()
+
+		if maxSize > 0 {
+			conn.SetReadLimit(maxSize)
+		}
+
+		extend := func() {}
+		if pingInterval > 0 {
+			extend = func() { conn.SetReadDeadline(time.Now().Add(2 * pingInterval)) }
+			extend()
+			conn.SetPongHandler(func(string) error {
+				extend()
+				return nil
+			})
+
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				t := time.NewTicker(pingInterval)
+				defer t.Stop()
+				for {
+					select {
+					case <-t.C:
+						if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+							return
+						}
+					case <-done:
+						return
+					}
+				}
+			}()
+		}
+
+		for {
+			typ, msg, err := conn.NextReader()
+			if err != nil {
+				return
+			}
+			extend()
+
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			out, err := conn.NextWriter(typ)
+			if err != nil {
+				return
+			}
+			if _, err := io.Copy(out, msg); err != nil {
+				return
+			}
+			if err := out.Close(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// hijackWriter lets the upgrader take over the connection underneath the
+// response writers of the middleware, which only expose it through Unwrap.
+type hijackWriter struct {
+	http.ResponseWriter
+}
+
+// Hijack implements the http.Hijacker interface.
+func (w hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}